@@ -0,0 +1,58 @@
+package currency
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// cp1251High maps windows-1251 bytes 0x80-0xBF to runes,
+// bytes 0xC0-0xFF map to U+0410-U+044F.
+var cp1251High = [64]rune{
+	0x0402, 0x0403, 0x201A, 0x0453, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x20AC, 0x2030, 0x0409, 0x2039, 0x040A, 0x040C, 0x040B, 0x040F,
+	0x0452, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0xFFFD, 0x2122, 0x0459, 0x203A, 0x045A, 0x045C, 0x045B, 0x045F,
+	0x00A0, 0x040E, 0x045E, 0x0408, 0x00A4, 0x0490, 0x00A6, 0x00A7,
+	0x0401, 0x00A9, 0x0404, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x0407,
+	0x00B0, 0x00B1, 0x0406, 0x0456, 0x0491, 0x00B5, 0x00B6, 0x00B7,
+	0x0451, 0x2116, 0x0454, 0x00BB, 0x0458, 0x0405, 0x0455, 0x0457,
+}
+
+// cp1251Reader decodes windows-1251 input into UTF-8.
+type cp1251Reader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (c *cp1251Reader) Read(p []byte) (n int, err error) {
+	for len(c.buf) < 1 {
+		raw := make([]byte, len(p))
+		var m int
+		if m, err = c.r.Read(raw); m < 1 {
+			return 0, err
+		}
+		for _, b := range raw[:m] {
+			c.buf = appendRune(c.buf, decodeByte(b))
+		}
+	}
+	n = copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func decodeByte(b byte) rune {
+	switch {
+	case b < 0x80:
+		return rune(b)
+	case b < 0xC0:
+		return cp1251High[b-0x80]
+	default:
+		return rune(b-0xC0) + 0x0410
+	}
+}
+
+func appendRune(buf []byte, r rune) []byte {
+	var tmp [utf8.UTFMax]byte
+	n := utf8.EncodeRune(tmp[:], r)
+	return append(buf, tmp[:n]...)
+}
@@ -0,0 +1,167 @@
+package currency
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xlab/api"
+	"github.com/xlab/smscloud/misc"
+)
+
+const baseURI = "http://www.cbr.ru/scripts"
+
+// RUB is the base currency of the CBR feed, it's not listed in the rates.
+const RUB = "RUB"
+
+var (
+	ErrUnknown = errors.New("currency: unknown currency")
+	ErrEmpty   = errors.New("currency: empty rates")
+)
+
+// Rate is a decimal value formatted with a comma separator.
+type Rate float64
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	s := strings.Replace(strings.TrimSpace(string(text)), ",", ".", 1)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*r = Rate(f)
+	return nil
+}
+
+type Valute struct {
+	ID       string `xml:"ID,attr"`
+	NumCode  string `xml:"NumCode"`
+	CharCode string `xml:"CharCode"`
+	Nominal  int    `xml:"Nominal"`
+	Name     string `xml:"Name"`
+	Value    Rate   `xml:"Value"`
+}
+
+// UnitRate returns RUB value of a single unit of the currency.
+func (v *Valute) UnitRate() float64 {
+	if v.Nominal < 1 {
+		return float64(v.Value)
+	}
+	return float64(v.Value) / float64(v.Nominal)
+}
+
+type DailyRates struct {
+	XMLName xml.Name  `xml:"ValCurs"`
+	Date    string    `xml:"Date,attr"`
+	Name    string    `xml:"name,attr"`
+	Valutes []*Valute `xml:"Valute"`
+}
+
+// Find looks up a currency by its ISO char code, e.g. USD.
+func (d *DailyRates) Find(code string) *Valute {
+	code = strings.ToUpper(code)
+	for _, v := range d.Valutes {
+		if v.CharCode == code {
+			return v
+		}
+	}
+	return nil
+}
+
+// Convert converts the amount between two currencies using RUB as a cross rate.
+func (d *DailyRates) Convert(amount float64, from, to string) (float64, error) {
+	fromRate, err := d.unitRate(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := d.unitRate(to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * fromRate / toRate, nil
+}
+
+func (d *DailyRates) unitRate(code string) (float64, error) {
+	if strings.ToUpper(code) == RUB {
+		return 1, nil
+	}
+	v := d.Find(code)
+	if v == nil {
+		return 0, ErrUnknown
+	}
+	return v.UnitRate(), nil
+}
+
+type Api struct {
+	// Client is used to make requests, misc.DefaultHTTPClient if nil.
+	Client *http.Client
+	*api.Api
+}
+
+func NewApi() *Api {
+	return &Api{
+		Api: api.MustNew(baseURI),
+	}
+}
+
+// SetBaseURL points the Api to another endpoint, e.g. a test server.
+func (a *Api) SetBaseURL(base string) (err error) {
+	var b *api.Api
+	if b, err = api.New(base); err != nil {
+		return
+	}
+	a.Api = b
+	return
+}
+
+// Query fetches the latest daily rates.
+func (a *Api) Query(ctx context.Context) (res *DailyRates, err error) {
+	var req *http.Request
+	var resp *http.Response
+	if req, err = a.Request(api.GET, "/XML_daily.asp", url.Values{}); err != nil {
+		return
+	}
+	// do a request
+	if resp, err = a.client().Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var data []byte
+	if data, err = ioutil.ReadAll(resp.Body); err != nil {
+		return
+	}
+	// parse result
+	res = &DailyRates{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = charsetReader
+	if err = dec.Decode(res); err != nil {
+		return nil, err
+	}
+	if len(res.Valutes) < 1 {
+		return nil, ErrEmpty
+	}
+	return
+}
+
+func (a *Api) client() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return misc.DefaultHTTPClient
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "windows-1251", "cp1251":
+		return &cp1251Reader{r: input}, nil
+	case "utf-8", "utf8":
+		return input, nil
+	}
+	return nil, errors.New("currency: unsupported charset " + charset)
+}
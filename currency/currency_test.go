@@ -0,0 +1,68 @@
+package currency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestApi returns an Api pointed at a local server
+// that serves the recorded daily rates.
+func newTestApi() (*Api, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/XML_daily.asp" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "testdata/XML_daily.xml")
+	}))
+	a := NewApi()
+	a.SetBaseURL(srv.URL)
+	return a, srv
+}
+
+func TestQuery(t *testing.T) {
+	a, srv := newTestApi()
+	defer srv.Close()
+	res, err := a.Query(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "16.10.2026", res.Date)
+	assert.Len(t, res.Valutes, 7)
+	usd := res.Find("usd")
+	if assert.NotNil(t, usd) {
+		assert.Equal(t, "Доллар США", usd.Name)
+		assert.Equal(t, 1, usd.Nominal)
+		assert.InDelta(t, 79.2853, usd.UnitRate(), 1e-9)
+	}
+	kzt := res.Find("KZT")
+	if assert.NotNil(t, kzt) {
+		assert.Equal(t, 100, kzt.Nominal)
+		assert.InDelta(t, 0.147016, kzt.UnitRate(), 1e-9)
+	}
+	assert.Nil(t, res.Find("XXX"))
+}
+
+func TestConvert(t *testing.T) {
+	a, srv := newTestApi()
+	defer srv.Close()
+	res, err := a.Query(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	v, err := res.Convert(100, "USD", RUB)
+	assert.NoError(t, err)
+	assert.InDelta(t, 7928.53, v, 1e-6)
+	v, err = res.Convert(1000, "rub", "eur")
+	assert.NoError(t, err)
+	assert.InDelta(t, 1000/92.6345, v, 1e-6)
+	v, err = res.Convert(1, "EUR", "USD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 92.6345/79.2853, v, 1e-6)
+	_, err = res.Convert(1, "XXX", "USD")
+	assert.Equal(t, ErrUnknown, err)
+}
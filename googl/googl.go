@@ -1,6 +1,7 @@
 package googl
 
 import (
+	"net/http"
 	"net/url"
 
 	"code.google.com/p/goauth2/oauth/jwt"
@@ -22,6 +23,12 @@ func NewShortener(issuer string, key []byte) (s *Shortener, err error) {
 	return
 }
 
+// SetTransport sets the underlying transport used for both token and
+// shortener requests, http.DefaultTransport if nil.
+func (s *Shortener) SetTransport(rt http.RoundTripper) {
+	s.transport.Transport = rt
+}
+
 func (s *Shortener) Short(u *url.URL) (short *url.URL, err error) {
 	tmp := &urlshortener.Url{
 		LongUrl: u.String(),
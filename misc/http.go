@@ -0,0 +1,31 @@
+package misc
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+const DefaultHTTPTimeout = 30 * time.Second
+
+// DefaultHTTPClient is used by the API clients unless another one is set.
+var DefaultHTTPClient = NewHTTPClient(DefaultHTTPTimeout)
+
+// NewHTTPClient creates a client with the overall request timeout
+// that keeps idle connections to the APIs for reuse.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost:   8,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+}
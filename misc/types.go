@@ -1,6 +1,10 @@
 package misc
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Message struct {
 	UUID        []byte
@@ -11,6 +15,64 @@ type Message struct {
 	OpTimestamp time.Time
 }
 
+// Topics that carry replies to be sent by the sc-client modems,
+// the reports on how the sending went and the delivery reports.
+const (
+	OutgoingTopicPrefix = "outgoing_"
+	ReportTopic         = "send_reports"
+	DeliveryTopic       = "delivery_reports"
+)
+
+const modemIdSep = ":"
+
+// OutgoingTopic returns the topic the named modem takes replies from.
+func OutgoingTopic(modem string) string {
+	return OutgoingTopicPrefix + modem
+}
+
+// Outgoing is a message to be sent by the modem.
+type Outgoing struct {
+	UUID      []byte
+	Origin    string // sender modem's name
+	Text      string
+	Address   string // recipient's address
+	Timestamp time.Time
+	// Deadline is when sc-server falls back to another sender,
+	// the modem must not send the message after it.
+	Deadline time.Time
+}
+
+// SendReport tells whether the modem has sent the outgoing message.
+type SendReport struct {
+	UUID   []byte // of the outgoing message
+	Origin string
+	Sent   bool
+	Error  string
+	// Reference is the message reference of the sent message,
+	// its DeliveryReport comes with it.
+	Reference byte
+	Timestamp time.Time
+}
+
+// DeliveryReport is a status report on a message sent by the modem.
+type DeliveryReport struct {
+	Origin    string
+	Reference byte
+	Status    byte // TP-Status of the report
+	Timestamp time.Time
+}
+
+// ModemMessageId returns the ID of the message sent by the named modem
+// with the reference, delivery reports are matched by it.
+func ModemMessageId(modem string, ref byte) string {
+	return modem + modemIdSep + strconv.Itoa(int(ref))
+}
+
+// IsModemMessageId reports whether the ID is of a message sent by a modem.
+func IsModemMessageId(id string) bool {
+	return strings.Contains(id, modemIdSep)
+}
+
 type Notification struct {
 	Kind  NotifyType
 	Value string // frontend JS value = who cares
@@ -23,6 +85,8 @@ const (
 	NotifySuccess
 	NotifyReserve
 	NotifyError
+	NotifyDelivery
+	NotifyBudget
 )
 
 func (n NotifyType) String() string {
@@ -35,6 +99,10 @@ func (n NotifyType) String() string {
 		return "Reserved amount changed"
 	case NotifyError:
 		return "Error occured"
+	case NotifyDelivery:
+		return "Delivery rate changed"
+	case NotifyBudget:
+		return "Budget limit reached"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,230 @@
+// Package replay implements an HTTP transport that records responses of
+// the real APIs into fixture files and replays them in tests, so package
+// tests can run offline. Run tests with REPLAY_RECORD=1 in the environment
+// to refresh the fixtures, e.g. REPLAY_RECORD=1 go test ./...
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// RecordEnv is the environment variable that turns the record mode on.
+// It's not a flag since go test ./... passes flags to every test binary,
+// including the ones that don't use replay.
+const RecordEnv = "REPLAY_RECORD"
+
+var record = len(os.Getenv(RecordEnv)) > 0
+
+const redacted = "REDACTED"
+
+// skipHeaders are not stored: sensitive ones, Content-Type that's stored
+// separately and the ones that don't hold for the replayed response.
+var skipHeaders = []string{
+	"Authorization", "Cookie", "Set-Cookie",
+	"Content-Type", "Content-Length", "Date",
+}
+
+// Entry is a single recorded exchange.
+type Entry struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	Status      int         `json:"status"`
+	ContentType string      `json:"content_type"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body"`
+}
+
+// Transport replays recorded responses, or records them in the record mode.
+type Transport struct {
+	// Base is used to reach the real APIs when recording,
+	// http.DefaultTransport if nil.
+	Base http.RoundTripper
+	// Redact lists query parameters, e.g. API keys, that are not stored
+	// and not taken into account when matching requests. The values of
+	// response headers and JSON body fields with these names, e.g. tokens,
+	// are not stored either.
+	Redact []string
+
+	path      string
+	recording bool
+	mux       sync.Mutex
+	entries   []*Entry
+	used      map[*Entry]bool
+}
+
+// Open loads fixtures from the path, in the record mode
+// the path will be overwritten on Close.
+func Open(path string, redact ...string) (t *Transport, err error) {
+	t = &Transport{
+		Redact:    redact,
+		path:      path,
+		recording: record,
+		used:      make(map[*Entry]bool),
+	}
+	if t.recording {
+		return
+	}
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &t.entries); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Recording reports whether the transport is in the record mode.
+func (t *Transport) Recording() bool {
+	return t.recording
+}
+
+// Client returns an http client that uses the transport.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.recording {
+		return t.recordTrip(req)
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	key := t.redact(req.URL)
+	var found *Entry
+	for _, e := range t.entries {
+		if e.Method != req.Method || e.URL != key {
+			continue
+		}
+		found = e
+		if !t.used[e] {
+			break
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("replay: no fixture for %s %s in %s", req.Method, key, t.path)
+	}
+	t.used[found] = true
+	return found.response(req), nil
+}
+
+func (t *Transport) recordTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		Method:      req.Method,
+		URL:         t.redact(req.URL),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Header:      t.redactHeader(resp.Header),
+		Body:        t.redactBody(string(body)),
+	}
+	t.mux.Lock()
+	t.entries = append(t.entries, e)
+	t.mux.Unlock()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Close saves the recorded fixtures, it does nothing when replaying.
+func (t *Transport) Close() error {
+	if !t.recording {
+		return nil
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.entries) < 1 {
+		return errors.New("replay: nothing recorded for " + t.path)
+	}
+	data, err := json.MarshalIndent(t.entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.path, append(data, '\n'), 0644)
+}
+
+func (t *Transport) redact(u *url.URL) string {
+	v := u.Query()
+	for _, name := range t.Redact {
+		if _, ok := v[name]; ok {
+			v.Set(name, redacted)
+		}
+	}
+	tmp := *u
+	tmp.RawQuery = v.Encode()
+	return tmp.String()
+}
+
+// redactHeader copies the header without the skipped and redacted values.
+func (t *Transport) redactHeader(h http.Header) http.Header {
+	header := make(http.Header, len(h))
+	for k, v := range h {
+		header[k] = v
+	}
+	for _, name := range skipHeaders {
+		header.Del(name)
+	}
+	for _, name := range t.Redact {
+		if len(header.Get(name)) > 0 {
+			header.Set(name, redacted)
+		}
+	}
+	if len(header) < 1 {
+		return nil
+	}
+	return header
+}
+
+// redactBody replaces string values of the redacted JSON fields.
+func (t *Transport) redactBody(body string) string {
+	for _, name := range t.Redact {
+		re := regexp.MustCompile(`("` + regexp.QuoteMeta(name) + `"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+		body = re.ReplaceAllString(body, `${1}"`+redacted+`"`)
+	}
+	return body
+}
+
+func (e *Entry) response(req *http.Request) *http.Response {
+	header := make(http.Header, len(e.Header)+1)
+	for k, v := range e.Header {
+		header[k] = v
+	}
+	if len(e.ContentType) > 0 {
+		header.Set("Content-Type", e.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(e.Body))),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,115 @@
+package replay
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello "+r.URL.Query().Get("name"))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "replay")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	// record
+	record = true
+	tr, err := Open(path, "key")
+	record = false
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := tr.Client().Get(srv.URL + "/?name=world&key=secret")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	if !assert.NoError(t, tr.Close()) {
+		return
+	}
+	data, _ := ioutil.ReadFile(path)
+	assert.NotContains(t, string(data), "secret")
+
+	// replay with a different key and no server
+	srv.Close()
+	tr, err = Open(path, "key")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err = tr.Client().Get(srv.URL + "/?name=world&key=other")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+
+	_, err = tr.Client().Get(srv.URL + "/?name=nobody")
+	assert.Error(t, err)
+}
+
+func TestRecordRedactBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-secret"})
+		w.Header().Set("X-Token", "header-secret")
+		w.Header().Set("X-Served-By", "test")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token": "body\"secret", "expires_in": 3600}`)
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "replay")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	record = true
+	tr, err := Open(path, "access_token", "X-Token")
+	record = false
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := tr.Client().Get(srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	// the caller gets the real response
+	assert.Contains(t, string(body), "secret")
+	if !assert.NoError(t, tr.Close()) {
+		return
+	}
+	data, _ := ioutil.ReadFile(path)
+	assert.NotContains(t, string(data), "secret")
+
+	tr, err = Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err = tr.Client().Get(srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"access_token": "REDACTED", "expires_in": 3600}`, string(body))
+	assert.Equal(t, "REDACTED", resp.Header.Get("X-Token"))
+	assert.Equal(t, "test", resp.Header.Get("X-Served-By"))
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+}
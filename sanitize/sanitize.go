@@ -0,0 +1,214 @@
+// Package sanitize rewrites service replies to fit SMS with chains
+// of rules loaded from config, each service may have its own chain.
+package sanitize
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultChain is applied to the services that have no chain of their own.
+const DefaultChain = "default"
+
+// Rule types.
+const (
+	RegexRule    = "regex"    // replaces Pattern matches with Replace
+	ReplaceRule  = "replace"  // replaces literal Pairs in order
+	UnitsRule    = "units"    // abbreviates whole words by Units
+	TableRule    = "table"    // flattens Wolfram | tables
+	CollapseRule = "collapse" // collapses whitespace runs into a space
+)
+
+var (
+	ErrRuleType = errors.New("sanitize: unknown rule type")
+	ErrPairs    = errors.New("sanitize: replace pairs must have two items")
+)
+
+// Rule is a single rewrite step.
+type Rule struct {
+	Type    string            `json:"type"`
+	Pattern string            `json:"pattern"`
+	Replace string            `json:"replace"`
+	Pairs   [][]string        `json:"pairs"`
+	Units   map[string]string `json:"units"`
+}
+
+// Config holds rule chains by service name.
+type Config struct {
+	Chains map[string][]*Rule `json:"chains"`
+}
+
+func (c *Config) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, c)
+}
+
+type step func(string) string
+
+// Pipeline applies compiled rule chains.
+type Pipeline struct {
+	chains map[string][]step
+}
+
+// New compiles the chains of the config.
+func New(cfg *Config) (p *Pipeline, err error) {
+	p = &Pipeline{chains: make(map[string][]step)}
+	for name, rules := range cfg.Chains {
+		chain := make([]step, 0, len(rules))
+		for _, rule := range rules {
+			var s step
+			if s, err = compile(rule); err != nil {
+				return nil, errors.New(err.Error() + " in chain " + name)
+			}
+			chain = append(chain, s)
+		}
+		p.chains[name] = chain
+	}
+	return
+}
+
+// Apply rewrites the reply of the service, the result is trimmed.
+func (p *Pipeline) Apply(svc, reply string) string {
+	chain, ok := p.chains[svc]
+	if !ok {
+		chain = p.chains[DefaultChain]
+	}
+	for _, s := range chain {
+		reply = s(reply)
+	}
+	return strings.TrimSpace(reply)
+}
+
+func compile(rule *Rule) (step, error) {
+	switch rule.Type {
+	case RegexRule:
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return func(s string) string {
+			return re.ReplaceAllString(s, rule.Replace)
+		}, nil
+	case ReplaceRule:
+		pairs := make([]string, 0, 2*len(rule.Pairs))
+		for _, pair := range rule.Pairs {
+			if len(pair) != 2 {
+				return nil, ErrPairs
+			}
+			pairs = append(pairs, pair...)
+		}
+		return strings.NewReplacer(pairs...).Replace, nil
+	case UnitsRule:
+		return unitsStep(rule.Units)
+	case TableRule:
+		return flattenTables, nil
+	case CollapseRule:
+		return collapse, nil
+	}
+	return nil, ErrRuleType
+}
+
+var space = regexp.MustCompile(`\s+`)
+
+func collapse(s string) string {
+	return space.ReplaceAllString(s, " ")
+}
+
+// unitsStep abbreviates the units found as whole words in any case.
+func unitsStep(units map[string]string) (step, error) {
+	abbr := make(map[string]string, len(units))
+	names := make([]string, 0, len(units))
+	for name, short := range units {
+		abbr[strings.ToLower(name)] = short
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	if len(names) < 1 {
+		return func(s string) string { return s }, nil
+	}
+	// longer names first, so "square kilometers" wins over "kilometers"
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	re, err := regexp.Compile(`(?i)(\pL*?)(` + strings.Join(names, "|") + `)(\pL*)`)
+	if err != nil {
+		return nil, err
+	}
+	return func(s string) string {
+		var out []string
+		var last int
+		for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+			// a part of a longer word is left as is
+			if m[3] > m[2] || m[7] > m[6] {
+				continue
+			}
+			out = append(out, s[last:m[4]], abbr[strings.ToLower(s[m[4]:m[5]])])
+			last = m[5]
+		}
+		return strings.Join(append(out, s[last:]), "")
+	}, nil
+}
+
+// flattenTables turns the lines of Wolfram plaintext tables into
+// "row: value" items separated by semicolons. A table starting with
+// an empty cell has a header, so values are prefixed with the column names.
+func flattenTables(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	var table [][]string
+	flush := func() {
+		if len(table) > 0 {
+			out = append(out, flattenTable(table))
+			table = nil
+		}
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "|") {
+			flush()
+			out = append(out, line)
+			continue
+		}
+		cells := strings.Split(line, "|")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+		}
+		table = append(table, cells)
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
+
+func flattenTable(rows [][]string) string {
+	var header []string
+	if len(rows) > 1 && len(rows[0][0]) < 1 {
+		header, rows = rows[0], rows[1:]
+	}
+	items := make([]string, 0, len(rows))
+	for _, row := range rows {
+		values := make([]string, 0, len(row)-1)
+		for i, cell := range row[1:] {
+			if len(cell) < 1 {
+				continue
+			}
+			if i+1 < len(header) && len(header[i+1]) > 0 {
+				cell = header[i+1] + " " + cell
+			}
+			values = append(values, cell)
+		}
+		switch {
+		case len(row[0]) < 1:
+			items = append(items, strings.Join(values, ", "))
+		case len(values) < 1:
+			items = append(items, row[0])
+		default:
+			items = append(items, row[0]+": "+strings.Join(values, ", "))
+		}
+	}
+	return strings.Join(items, "; ")
+}
@@ -0,0 +1,65 @@
+package sanitize
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `{
+	"chains": {
+		"default": [
+			{"type": "regex", "pattern": "\\(\\s*\\)", "replace": ""},
+			{"type": "collapse"}
+		],
+		"wolfram": [
+			{"type": "table"},
+			{"type": "units", "units": {
+				"kilometers": "km",
+				"square kilometers": "km2",
+				"meters": "m",
+				"километров": "км"
+			}},
+			{"type": "replace", "pairs": [[" (mean)", ""], ["×10^", "e"]]},
+			{"type": "collapse"}
+		]
+	}
+}`
+
+func newTestPipeline(t *testing.T) *Pipeline {
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(testConfig), cfg); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestApplyDefault(t *testing.T) {
+	p := newTestPipeline(t)
+	assert.Equal(t, "Shovel a tool", p.Apply("wikipedia", "  Shovel ( )  a\n tool "))
+	assert.Equal(t, "x", p.Apply(DefaultChain, "x ()"))
+}
+
+func TestApplyWolfram(t *testing.T) {
+	p := newTestPipeline(t)
+	assert.Equal(t, "radius: 6371.01 km; area: 5.1007e8 km2",
+		p.Apply("wolfram", "radius | 6371.01 kilometers (mean)\narea | 5.1007×10^8 square kilometers"))
+	assert.Equal(t, "US dollar: minimum $0.0104, maximum $0.0131, average $0.0119",
+		p.Apply("wolfram", "  | minimum | maximum | average\nUS dollar | $0.0104 | $0.0131 | $0.0119"))
+	assert.Equal(t, "1 km = 1000 m", p.Apply("wolfram", "1 Kilometers = 1000 meters"))
+	assert.Equal(t, "kilometersx 5 км", p.Apply("wolfram", "kilometersx 5 километров"))
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(&Config{Chains: map[string][]*Rule{"x": {{Type: "magic"}}}})
+	assert.Error(t, err)
+	_, err = New(&Config{Chains: map[string][]*Rule{"x": {{Type: ReplaceRule, Pairs: [][]string{{"a"}}}}}})
+	assert.Error(t, err)
+	_, err = New(&Config{Chains: map[string][]*Rule{"x": {{Type: RegexRule, Pattern: "("}}}})
+	assert.Error(t, err)
+}
@@ -15,12 +15,14 @@ import (
 	"github.com/bitly/go-nsq"
 	"github.com/codegangsta/cli"
 	"github.com/xlab/smscloud/misc"
+	"github.com/xlab/smscloud/service"
 )
 
 const smsMsgThroughput = 200
 
 const (
-	pubTopic = "messages"
+	pubTopic   = "messages"
+	subChannel = "sc_client"
 )
 
 var app = cli.NewApp()
@@ -50,11 +52,6 @@ func init() {
 	}
 }
 
-var supportedNames = map[string]bool{
-	"wolfram":   true,
-	"wikipedia": true,
-}
-
 func main() {
 	app.Action = func(c *cli.Context) {
 		if !c.IsSet("nsqd") {
@@ -75,6 +72,42 @@ func main() {
 		if err != nil {
 			log.Fatalln(err)
 		}
+		go func() {
+			for report := range hdl.reports {
+				body, err := json.Marshal(report)
+				if err != nil {
+					log.Printf("failed to marshal report %x", report.UUID)
+					continue
+				}
+				if err = producer.Publish(misc.ReportTopic, body); err != nil {
+					log.Printf("failed to publish report %x", report.UUID)
+				}
+			}
+		}()
+		go func() {
+			for report := range hdl.deliveries {
+				body, err := json.Marshal(report)
+				if err != nil {
+					log.Printf("failed to marshal delivery report %d of %s", report.Reference, report.Origin)
+					continue
+				}
+				if err = producer.Publish(misc.DeliveryTopic, body); err != nil {
+					log.Printf("failed to publish delivery report %d of %s", report.Reference, report.Origin)
+				}
+			}
+		}()
+		// outgoing messages are taken by the modem they're addressed to
+		for _, mon := range hdl.mons {
+			consumer, err := nsq.NewConsumer(misc.OutgoingTopic(mon.Name()), subChannel, cfg)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			consumer.AddHandler(mon)
+			consumer.SetLogger(nsqLog, nsq.LogLevelDebug)
+			if err = consumer.ConnectToNSQD(c.String("nsqd")); err != nil {
+				log.Fatalln(err)
+			}
+		}
 		go func() {
 			for msg := range hdl.messages {
 				body, err := json.Marshal(msg)
@@ -95,8 +128,10 @@ func main() {
 }
 
 type monitorHandler struct {
-	mons     []*Monitor
-	messages chan *misc.Message
+	mons       []*Monitor
+	messages   chan *misc.Message
+	reports    chan *misc.SendReport
+	deliveries chan *misc.DeliveryReport
 }
 
 func (m *monitorHandler) Handle(port int) {
@@ -125,6 +160,8 @@ func (m *monitorHandler) Handle(port int) {
 func newMonitorHandler(dir string) (handler *monitorHandler, err error) {
 	handler = &monitorHandler{}
 	handler.messages = make(chan *misc.Message, smsMsgThroughput)
+	handler.reports = make(chan *misc.SendReport, smsMsgThroughput)
+	handler.deliveries = make(chan *misc.DeliveryReport, smsMsgThroughput)
 	var list []os.FileInfo
 	if list, err = ioutil.ReadDir(dir); err != nil {
 		err = errors.New("sc-client: unable to read config dir " + dir)
@@ -138,7 +175,7 @@ func newMonitorHandler(dir string) (handler *monitorHandler, err error) {
 		if cfg, err := loadConfig(path); err != nil {
 			continue
 		} else {
-			handler.mons = append(handler.mons, NewMonitor(handler.messages, cfg))
+			handler.mons = append(handler.mons, NewMonitor(handler.messages, handler.reports, handler.deliveries, cfg))
 		}
 	}
 	if len(handler.mons) < 1 {
@@ -157,7 +194,7 @@ func loadConfig(path string) (cfg *MonitorConfig, err error) {
 		log.Println(err)
 		return
 	}
-	if !supportedNames[cfg.ModemName] {
+	if !service.Supported(cfg.ModemName) {
 		err = errors.New("sc-client: unsupported modem name " + cfg.ModemName)
 		log.Println(err)
 		return
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
+	"github.com/bitly/go-nsq"
 	"github.com/xlab/at"
+	"github.com/xlab/at/sms"
 	"github.com/xlab/smscloud/misc"
+	"github.com/xlab/smscloud/sender"
 )
 
 const (
@@ -13,6 +18,11 @@ const (
 	DeviceCheckInterval  = time.Second * 10
 )
 
+var (
+	errNotReady = errors.New("device is not ready")
+	errExpired  = errors.New("deadline exceeded")
+)
+
 type State uint8
 
 const (
@@ -34,6 +44,8 @@ type Monitor struct {
 	dev          *at.Device
 	stateChanged chan State
 	messages     chan<- *misc.Message
+	reports      chan<- *misc.SendReport
+	deliveries   chan<- *misc.DeliveryReport
 	checkTimer   *time.Timer
 	uptimeBase   time.Time
 }
@@ -60,7 +72,8 @@ type MonitorConfig struct {
 	BalanceUSSD     string `json:"balance_ussd"`
 }
 
-func NewMonitor(messages chan<- *misc.Message, cfg *MonitorConfig) *Monitor {
+func NewMonitor(messages chan<- *misc.Message, reports chan<- *misc.SendReport,
+	deliveries chan<- *misc.DeliveryReport, cfg *MonitorConfig) *Monitor {
 	return &Monitor{
 		name:        cfg.ModemName,
 		cmdPort:     cfg.CommandPortPath,
@@ -68,6 +81,8 @@ func NewMonitor(messages chan<- *misc.Message, cfg *MonitorConfig) *Monitor {
 		balanceUSSD: cfg.BalanceUSSD,
 
 		messages:     messages,
+		reports:      reports,
+		deliveries:   deliveries,
 		stateChanged: make(chan State, 10),
 	}
 }
@@ -143,6 +158,13 @@ func (m *Monitor) Run() (err error) {
 							OpTimestamp: time.Time(msg.ServiceCenterTime),
 						}
 						m.messages <- wrap
+					case r := <-m.dev.StatusReports():
+						m.deliveries <- &misc.DeliveryReport{
+							Origin:    m.name,
+							Reference: r.MessageReference,
+							Status:    r.Status,
+							Timestamp: time.Now(),
+						}
 					case <-t.C:
 						m.dev.SendUSSD(m.balanceUSSD)
 					}
@@ -167,3 +189,40 @@ func (m *Monitor) openDevice() (err error) {
 	}
 	return
 }
+
+// HandleMessage sends an outgoing message published by sc-server
+// and reports back whether it has been sent. Messages past their deadline
+// aren't sent, sc-server has used another sender by then.
+func (m *Monitor) HandleMessage(nmsg *nsq.Message) error {
+	var out misc.Outgoing
+	if err := json.Unmarshal(nmsg.Body, &out); err != nil {
+		return err
+	}
+	report := &misc.SendReport{
+		UUID:   out.UUID,
+		Origin: m.name,
+		Sent:   true,
+	}
+	err := errExpired
+	if out.Deadline.IsZero() || time.Now().Before(out.Deadline) {
+		report.Reference, err = m.SendSMS(out.Address, out.Text)
+	}
+	if err != nil {
+		log.Printf("failed to send msg %x via %s: %s", out.UUID, m.name, err.Error())
+		report.Sent = false
+		report.Error = err.Error()
+	}
+	report.Timestamp = time.Now()
+	m.reports <- report
+	return nil
+}
+
+// SendSMS sends the text with the device if it's ready and returns
+// the message reference its delivery is reported with.
+func (m *Monitor) SendSMS(address, text string) (ref byte, err error) {
+	dev := m.dev
+	if !m.Ready || dev == nil {
+		return 0, errNotReady
+	}
+	return sender.SendSMS(dev, text, sms.PhoneNumber(address))
+}
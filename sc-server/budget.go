@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xlab/smscloud/misc"
+)
+
+const (
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+	// reserveRetry is how often sends deferred by the reserve are retried,
+	// the balance only grows when the account is topped up.
+	reserveRetry = 30 * time.Minute
+	reserveLimit = "reserve"
+)
+
+// noticeService is the allocation reminders and digests are spent from.
+const noticeService = "notices"
+
+type budgetConfig struct {
+	// Spend caps, zero disables a cap.
+	DailyCap   float32 `json:"daily_cap"`
+	MonthlyCap float32 `json:"monthly_cap"`
+	// MinReserve is the balance that is never spent.
+	MinReserve float32 `json:"min_reserve"`
+	// DegradeAt is the share of a cap after which replies are degraded
+	// to a single SMS without the link, zero disables degrading.
+	DegradeAt float32 `json:"degrade_at"`
+	// Services holds daily allocations of the services by name.
+	Services map[string]float32 `json:"services"`
+}
+
+func (b *budgetConfig) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, b)
+}
+
+// Spend is the money spent on the service messages during a day,
+// there's a single row per day and service.
+type Spend struct {
+	Id        int64
+	Day       string `sql:"size:10"`
+	Service   string `sql:"size:20"`
+	Cost      float32
+	Messages  int
+	UpdatedAt time.Time
+}
+
+// Limit is a budget limit hit by a send. A soft limit means the degrade
+// threshold is crossed, sends over a hard limit are deferred until it resets.
+type Limit struct {
+	Name  string
+	Soft  bool
+	Until time.Time
+}
+
+// deferredError is returned by sends put off by a hard limit.
+type deferredError struct {
+	limit *Limit
+}
+
+func (e *deferredError) Error() string {
+	return "budget: " + e.limit.Name + " reached, deferred until " + e.limit.Until.Format(time.Stamp)
+}
+
+// Budget accounts actual costs of the sent messages and checks
+// sends against the caps, the reserve and the service allocations.
+type Budget struct {
+	cfg   *budgetConfig
+	db    *gorm.DB
+	alert func(limit *Limit)
+
+	mux     sync.Mutex
+	balance float32
+	known   bool
+	alerted map[Limit]bool
+}
+
+func NewBudget(db *gorm.DB, cfg *budgetConfig, alert func(limit *Limit)) (b *Budget, err error) {
+	if err = db.AutoMigrate(Spend{}).Error; err != nil {
+		return nil, err
+	}
+	if err = db.Model(Spend{}).AddUniqueIndex("idx_spends_day_service", "day", "service").Error; err != nil {
+		return nil, err
+	}
+	b = &Budget{
+		cfg:     cfg,
+		db:      db,
+		alert:   alert,
+		alerted: make(map[Limit]bool),
+	}
+	return
+}
+
+// SetBalance updates the last known account balance.
+func (b *Budget) SetBalance(balance float32) {
+	b.mux.Lock()
+	b.balance = balance
+	b.known = true
+	if balance >= b.cfg.MinReserve {
+		// alert again once the reserve is reached after a top-up
+		delete(b.alerted, Limit{Name: reserveLimit})
+	}
+	b.mux.Unlock()
+}
+
+// Record adds the cost of the sent messages to the service spend.
+// It's added in place, so concurrent sends aren't lost.
+func (b *Budget) Record(svc string, cost float32, n int) (err error) {
+	day := time.Now().Format(dayFormat)
+	ok, err := b.add(day, svc, cost, n)
+	if err == nil && !ok {
+		spend := Spend{
+			Day:       day,
+			Service:   svc,
+			Cost:      cost,
+			Messages:  n,
+			UpdatedAt: time.Now(),
+		}
+		if err = b.db.Create(&spend).Error; err != nil {
+			// a concurrent send has created the day, the unique index refused it
+			_, err = b.add(day, svc, cost, n)
+		}
+	}
+	if err != nil {
+		return
+	}
+	b.mux.Lock()
+	if b.known {
+		b.balance -= cost
+	}
+	b.mux.Unlock()
+	return nil
+}
+
+// add adds the cost to the existing spend of the day, ok is false if there's none.
+func (b *Budget) add(day, svc string, cost float32, n int) (ok bool, err error) {
+	db := b.db.Exec("UPDATE spends SET cost = cost + ?, messages = messages + ?, updated_at = ? "+
+		"WHERE day = ? AND service = ?", cost, n, time.Now(), day, svc)
+	return db.RowsAffected > 0, db.Error
+}
+
+// Check returns the limit the send of the cost would hit, nil if none.
+// The operator is alerted once per limit until it resets.
+func (b *Budget) Check(svc string, cost float32) (limit *Limit, err error) {
+	now := time.Now()
+	today := now.Format(dayFormat)
+	var spends []*Spend
+	if err = b.db.Where("day >= ?", now.Format(monthFormat)+"-01").Find(&spends).Error; err != nil {
+		return
+	}
+	var day, month, service float32
+	for _, spend := range spends {
+		month += spend.Cost
+		if spend.Day == today {
+			day += spend.Cost
+			if spend.Service == svc {
+				service += spend.Cost
+			}
+		}
+	}
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+
+	b.mux.Lock()
+	balance, known := b.balance, b.known
+	b.mux.Unlock()
+	switch {
+	case known && balance-cost < b.cfg.MinReserve:
+		limit = &Limit{Name: reserveLimit, Until: now.Add(reserveRetry)}
+	case b.over(day+cost, b.cfg.DailyCap, 1):
+		limit = &Limit{Name: "daily cap", Until: tomorrow}
+	case b.over(month+cost, b.cfg.MonthlyCap, 1):
+		limit = &Limit{Name: "monthly cap", Until: nextMonth}
+	case b.over(service+cost, b.cfg.Services[svc], 1):
+		limit = &Limit{Name: svc + " allocation", Until: tomorrow}
+	case b.over(day+cost, b.cfg.DailyCap, b.cfg.DegradeAt):
+		limit = &Limit{Name: "daily cap", Soft: true, Until: tomorrow}
+	case b.over(month+cost, b.cfg.MonthlyCap, b.cfg.DegradeAt):
+		limit = &Limit{Name: "monthly cap", Soft: true, Until: nextMonth}
+	case b.over(service+cost, b.cfg.Services[svc], b.cfg.DegradeAt):
+		limit = &Limit{Name: svc + " allocation", Soft: true, Until: tomorrow}
+	default:
+		return nil, nil
+	}
+	b.notify(limit)
+	return limit, nil
+}
+
+// over reports whether the spend exceeds the share of the cap,
+// zero cap or share means no limit.
+func (b *Budget) over(spend, limit, share float32) bool {
+	return limit > 0 && share > 0 && spend > limit*share
+}
+
+func (b *Budget) notify(limit *Limit) {
+	key := *limit
+	if limit.Name == reserveLimit {
+		// the reserve limit has no period to reset
+		key.Until = time.Time{}
+	}
+	b.mux.Lock()
+	if b.alerted[key] {
+		b.mux.Unlock()
+		return
+	}
+	b.alerted[key] = true
+	b.mux.Unlock()
+	b.alert(limit)
+}
+
+// budgetReply degrades the reply to a single SMS without the link
+// if it would get over a budget limit. If the degraded reply is still
+// over a hard limit, over is set and the outbox defers the reply.
+// Replies relayed to a modem aren't spent from the budget.
+func (m *MessageHandler) budgetReply(req *Request, text string) (reply string, over bool, err error) {
+	if m.relays(req.Origin) {
+		return text, false, nil
+	}
+	cost, _, err := m.smsCost(req.Address, text)
+	if err != nil {
+		return
+	}
+	limit, err := m.budget.Check(req.Service, cost)
+	if err != nil || limit == nil {
+		return text, false, err
+	}
+	log.Printf("budget: %s reached, degrading reply to request %d", limit.Name, req.Id)
+	reply = m.singleSegment(req, replyText(req, "", 1), "")
+	if limit.Soft {
+		return reply, false, nil
+	}
+	if cost, _, err = m.smsCost(req.Address, reply); err != nil {
+		return
+	}
+	if limit, err = m.budget.Check(req.Service, cost); err != nil {
+		return
+	}
+	return reply, limit != nil && !limit.Soft, nil
+}
+
+// checkBudget returns a deferredError if the text would get over a hard limit.
+func (m *MessageHandler) checkBudget(svc, to, text string) error {
+	cost, _, err := m.smsCost(to, text)
+	if err != nil {
+		return err
+	}
+	limit, err := m.budget.Check(svc, cost)
+	if err != nil {
+		return err
+	}
+	if limit != nil && !limit.Soft {
+		return &deferredError{limit: limit}
+	}
+	return nil
+}
+
+func (m *MessageHandler) notifyBudget(limit *Limit) {
+	log.Printf("budget: %s reached until %s", limit.Name, limit.Until.Format(time.Stamp))
+	n := misc.Notification{
+		Kind:  misc.NotifyBudget,
+		Value: limit.Name,
+	}
+	if limit.Soft {
+		n.Value += " (degraded)"
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = m.stats.Publish(pubTopic, body); err != nil {
+		log.Println(err)
+	}
+}
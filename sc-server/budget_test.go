@@ -0,0 +1,137 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testBudget = &budgetConfig{
+	DailyCap:   100,
+	MonthlyCap: 1000,
+	MinReserve: 50,
+	DegradeAt:  0.8,
+	Services:   map[string]float32{"wolfram": 40},
+}
+
+func TestBudgetOver(t *testing.T) {
+	b := &Budget{}
+	tests := []struct {
+		spend, limit, share float32
+		over                bool
+	}{
+		{101, 100, 1, true},
+		{100, 100, 1, false},
+		{81, 100, 0.8, true},
+		{80, 100, 0.8, false},
+		{1000, 0, 1, false},
+		{1000, 100, 0, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.over, b.over(tt.spend, tt.limit, tt.share), "%v", tt)
+	}
+}
+
+func TestBudgetCheck(t *testing.T) {
+	db := openTestDB(t, Spend{})
+	var alerts []Limit
+	b, err := NewBudget(db, testBudget, func(limit *Limit) {
+		alerts = append(alerts, *limit)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Now()
+	today := now.Format(dayFormat)
+	earlier := today
+	if now.Day() > 1 {
+		earlier = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format(dayFormat)
+	}
+	spends := []*Spend{
+		{Day: today, Service: "wolfram", Cost: 30},
+		{Day: today, Service: "wikipedia", Cost: 40},
+	}
+	for _, spend := range spends {
+		if err = db.Create(spend).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		svc  string
+		cost float32
+		name string
+		soft bool
+	}{
+		{"wikipedia", 1, "", false},
+		{"wolfram", 1, "", false},
+		{"wolfram", 3, "wolfram allocation", true},   // 33 of 40
+		{"wolfram", 11, "wolfram allocation", false}, // 41 of 40
+		{"wikipedia", 11, "daily cap", true},         // 81 of 100
+		{"wikipedia", 31, "daily cap", false},        // 101 of 100
+	}
+	for _, tt := range tests {
+		limit, err := b.Check(tt.svc, tt.cost)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		if len(tt.name) < 1 {
+			assert.Nil(t, limit, "%v", tt)
+			continue
+		}
+		if assert.NotNil(t, limit, "%v", tt) {
+			assert.Equal(t, tt.name, limit.Name, "%v", tt)
+			assert.Equal(t, tt.soft, limit.Soft, "%v", tt)
+			assert.True(t, limit.Until.After(now))
+		}
+	}
+	if earlier != today {
+		if err = db.Create(&Spend{Day: earlier, Service: "wikipedia", Cost: 740}).Error; err != nil {
+			t.Fatal(err)
+		}
+		// 811 of 1000 this month
+		limit, err := b.Check("wikipedia", 1)
+		if assert.NoError(t, err) && assert.NotNil(t, limit) {
+			assert.Equal(t, "monthly cap", limit.Name)
+			assert.True(t, limit.Soft)
+		}
+	}
+
+	// the reserve comes first once the balance is known
+	b.SetBalance(60)
+	limit, err := b.Check("wikipedia", 11)
+	if assert.NoError(t, err) && assert.NotNil(t, limit) {
+		assert.Equal(t, reserveLimit, limit.Name)
+		assert.False(t, limit.Soft)
+	}
+	b.Check("wikipedia", 11)
+	n := len(alerts)
+	assert.Equal(t, reserveLimit, alerts[n-1].Name)
+	assert.NotEqual(t, reserveLimit, alerts[n-2].Name, "alerted once")
+}
+
+func TestBudgetRecord(t *testing.T) {
+	db := openTestDB(t, Spend{})
+	b, err := NewBudget(db, testBudget, func(*Limit) {})
+	if !assert.NoError(t, err) {
+		return
+	}
+	b.SetBalance(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, b.Record("wolfram", 0.5, 1))
+		}()
+	}
+	wg.Wait()
+	var spends []*Spend
+	if assert.NoError(t, db.Where("service = ?", "wolfram").Find(&spends).Error) &&
+		assert.Len(t, spends, 1) {
+		assert.Equal(t, float32(10), spends[0].Cost)
+		assert.Equal(t, 20, spends[0].Messages)
+	}
+	assert.Equal(t, float32(90), b.balance)
+}
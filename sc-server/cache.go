@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/codegangsta/cli"
+	"github.com/jinzhu/gorm"
+)
+
+// defaultCacheTTL is used for services that have no cache_ttl set.
+const defaultCacheTTL = 24 * time.Hour
+
+// CachedReply is a service reply stored for the normalized query.
+type CachedReply struct {
+	Id        int64
+	Service   string `sql:"size:20"`
+	Query     string `sql:"size:160"`
+	Reply     string
+	ShortUrl  string `sql:"size:20"`
+	Hits      int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// ReplyCache keeps service replies, so popular questions don't cost a query.
+type ReplyCache struct {
+	db   *gorm.DB
+	ttls map[string]time.Duration
+}
+
+// NewReplyCache creates a cache with the per-service TTLs,
+// a zero TTL means default and a negative one disables caching.
+func NewReplyCache(db *gorm.DB, ttls map[string]time.Duration) (c *ReplyCache, err error) {
+	if err = db.AutoMigrate(CachedReply{}).Error; err != nil {
+		return nil, err
+	}
+	return &ReplyCache{db: db, ttls: ttls}, nil
+}
+
+func (c *ReplyCache) ttl(svc string) time.Duration {
+	if ttl := c.ttls[svc]; ttl != 0 {
+		return ttl
+	}
+	return defaultCacheTTL
+}
+
+// Get returns a fresh cached reply to the query, hits are counted.
+func (c *ReplyCache) Get(svc, query string) (reply *CachedReply, ok bool, err error) {
+	if c.ttl(svc) < 0 {
+		return nil, false, nil
+	}
+	key := normalizeQuery(query)
+	reply = &CachedReply{}
+	if err = c.db.Where("service = ? AND query = ? AND expires_at > ?", svc, key, time.Now()).
+		First(reply).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	reply.Hits++
+	if err = c.db.Save(reply).Error; err != nil {
+		return nil, false, err
+	}
+	return reply, true, nil
+}
+
+// Put stores the reply to the query, replacing the previous one.
+func (c *ReplyCache) Put(svc, query, reply, shortUrl string) (err error) {
+	ttl := c.ttl(svc)
+	if ttl < 0 {
+		return nil
+	}
+	key := normalizeQuery(query)
+	if len(key) < 1 {
+		return nil
+	}
+	if err = c.db.Where("service = ? AND query = ?", svc, key).Delete(CachedReply{}).Error; err != nil {
+		return
+	}
+	now := time.Now()
+	cached := CachedReply{
+		Service:   svc,
+		Query:     key,
+		Reply:     reply,
+		ShortUrl:  shortUrl,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	return c.db.Create(&cached).Error
+}
+
+// Purge drops cached replies of the service, of all services if svc is empty,
+// the query narrows it down to a single entry. With expired set only stale
+// entries are dropped.
+func (c *ReplyCache) Purge(svc, query string, expired bool) (n int64, err error) {
+	db := c.db
+	if len(svc) > 0 {
+		db = db.Where("service = ?", svc)
+	}
+	if len(query) > 0 {
+		db = db.Where("query = ?", normalizeQuery(query))
+	}
+	if expired {
+		db = db.Where("expires_at <= ?", time.Now())
+	}
+	db = db.Delete(CachedReply{})
+	return db.RowsAffected, db.Error
+}
+
+// normalizeQuery makes a cache key of the query: it's lower cased,
+// punctuation around words is dropped and whitespace is collapsed.
+// Punctuation inside words is kept, so 3.14 and 314 stay apart.
+func normalizeQuery(query string) string {
+	words := strings.Fields(strings.ToLower(query))
+	key := words[:0]
+	for _, w := range words {
+		if w = strings.TrimFunc(w, unicode.IsPunct); len(w) > 0 {
+			key = append(key, w)
+		}
+	}
+	return strings.Join(key, " ")
+}
+
+// purgeCache is the purge-cache command action.
+func purgeCache(c *cli.Context) {
+	dbCfg := &dbConfig{}
+	if err := dbCfg.ReadFromFile(c.GlobalString("db-cfg")); err != nil {
+		log.Fatalln(err)
+	}
+	db, err := gorm.Open("postgres", dbCfg.DataSourceName())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cache, err := NewReplyCache(&db, nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	n, err := cache.Purge(c.String("service"), strings.Join(c.Args(), " "), c.Bool("expired"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("purged", n, "cached replies")
+}
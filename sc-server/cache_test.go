@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		in, key string
+	}{
+		{"Moscow", "moscow"},
+		{"  МОСКВА  ", "москва"},
+		{"what is\tthe   rouble?", "what is the rouble"},
+		{"«Война и мир»", "война и мир"},
+		{"pi, e!", "pi e"},
+		{"3.14", "3.14"},
+		{"314", "314"},
+		{"2+2", "2+2"},
+		{"?!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.key, normalizeQuery(tt.in), tt.in)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := &ReplyCache{ttls: map[string]time.Duration{
+		"currency":  time.Hour,
+		"wikipedia": -1,
+	}}
+	assert.Equal(t, time.Hour, c.ttl("currency"))
+	assert.Equal(t, defaultCacheTTL, c.ttl("wolfram"))
+
+	// disabled, nothing is stored or read
+	assert.NoError(t, c.Put("wikipedia", "moscow", "Moscow is the capital", ""))
+	_, ok, err := c.Get("wikipedia", "moscow")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestReplyCache(t *testing.T) {
+	db := openTestDB(t, CachedReply{})
+	c, err := NewReplyCache(db, map[string]time.Duration{"currency": time.Nanosecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, c.Put("wolfram", "What is the Rouble?", "Russian rubles", "http://goo.gl/x")) {
+		return
+	}
+	reply, ok, err := c.Get("wolfram", "what is  the rouble")
+	if assert.NoError(t, err) && assert.True(t, ok) {
+		assert.Equal(t, "Russian rubles", reply.Reply)
+		assert.Equal(t, "http://goo.gl/x", reply.ShortUrl)
+		assert.Equal(t, 1, reply.Hits)
+	}
+	_, ok, err = c.Get("wikipedia", "what is the rouble")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// replaced, not duplicated
+	assert.NoError(t, c.Put("wolfram", "what is the rouble", "RUB", ""))
+	reply, ok, err = c.Get("wolfram", "WHAT IS THE ROUBLE")
+	if assert.NoError(t, err) && assert.True(t, ok) {
+		assert.Equal(t, "RUB", reply.Reply)
+	}
+
+	// expired right away
+	assert.NoError(t, c.Put("currency", "usd", "60 RUB", ""))
+	time.Sleep(time.Millisecond)
+	_, ok, err = c.Get("currency", "usd")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	n, err := c.Purge("", "", true)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	n, err = c.Purge("wolfram", "What is the rouble", false)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// choiceWindow is how long the offered options can be chosen from.
+const choiceWindow = 15 * time.Minute
+
+const maxChoices = 9
+
+// Choice holds options offered to the sender on an ambiguous query.
+type Choice struct {
+	Id        int64
+	Address   string `sql:"size:20"`
+	Service   string `sql:"size:20"`
+	Options   string // newline separated
+	CreatedAt time.Time
+}
+
+// Choices keeps a single pending choice per sender.
+type Choices struct {
+	db *gorm.DB
+}
+
+func NewChoices(db *gorm.DB) (c *Choices, err error) {
+	if err = db.AutoMigrate(Choice{}).Error; err != nil {
+		return nil, err
+	}
+	return &Choices{db: db}, nil
+}
+
+// Offer stores the options for the sender and returns a numbered list reply.
+func (c *Choices) Offer(addr, svc string, options []string) (reply string, err error) {
+	if len(options) > maxChoices {
+		options = options[:maxChoices]
+	}
+	if err = c.db.Where("address = ?", addr).Delete(Choice{}).Error; err != nil {
+		return
+	}
+	choice := Choice{
+		Address:   addr,
+		Service:   svc,
+		Options:   strings.Join(options, "\n"),
+		CreatedAt: time.Now(),
+	}
+	if err = c.db.Create(&choice).Error; err != nil {
+		return
+	}
+	lines := make([]string, 0, len(options)+1)
+	lines = append(lines, "Уточните, ответив номером:")
+	for i, opt := range options {
+		lines = append(lines, fmt.Sprintf("%d %s", i+1, opt))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Peek returns the chosen option if the sender has a pending choice,
+// the choice is kept until it's dropped.
+func (c *Choices) Peek(addr string, n int) (svc, option string, ok bool, err error) {
+	var choice Choice
+	if err = c.db.Where("address = ? AND created_at > ?", addr, time.Now().Add(-choiceWindow)).
+		First(&choice).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return "", "", false, nil
+		}
+		return
+	}
+	options := strings.Split(choice.Options, "\n")
+	if n < 1 || n > len(options) {
+		return "", "", false, nil
+	}
+	return choice.Service, options[n-1], true, nil
+}
+
+// Drop drops the pending choice of the sender once an option is taken.
+func (c *Choices) Drop(addr string) error {
+	return c.db.Where("address = ?", addr).Delete(Choice{}).Error
+}
+
+func parseChoice(text string) (n int, ok bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || n < 1 || n > maxChoices {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChoices(t *testing.T) {
+	c, err := NewChoices(openTestDB(t, Choice{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Offer("1", "wikipedia", []string{"Paris", "Paris, Texas"})
+	assert.NoError(t, err)
+
+	_, _, ok, err := c.Peek("1", 3)
+	assert.NoError(t, err)
+	assert.False(t, ok, "no such option")
+	_, _, ok, err = c.Peek("2", 1)
+	assert.NoError(t, err)
+	assert.False(t, ok, "no choice offered")
+
+	// peeking keeps the choice, like a throttled request does
+	for i := 0; i < 2; i++ {
+		svc, option, ok, err := c.Peek("1", 2)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "wikipedia", svc)
+		assert.Equal(t, "Paris, Texas", option)
+	}
+	assert.NoError(t, c.Drop("1"))
+	_, _, ok, err = c.Peek("1", 1)
+	assert.NoError(t, err)
+	assert.False(t, ok, "dropped")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// testDBEnv points the database tests at a scratch postgres database,
+// e.g. SC_TEST_DB="dbname=sc_test sslmode=disable". They're skipped without it.
+const testDBEnv = "SC_TEST_DB"
+
+// openTestDB connects to the test database and empties the tables.
+func openTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	dsn := os.Getenv(testDBEnv)
+	if len(dsn) < 1 {
+		t.Skip(testDBEnv + " is not set")
+	}
+	db, err := gorm.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if err = db.AutoMigrate(table).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err = db.Delete(table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &db
+}
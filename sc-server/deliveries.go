@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/jinzhu/gorm"
+	"github.com/xlab/smscloud/misc"
+	"github.com/xlab/smscloud/sender"
+)
+
+// Delivery statuses of a reply.
+const (
+	deliveryUntracked int8 = iota // not sent or the sender can't tell
+	deliveryPending
+	deliveryDone
+	deliveryFailed
+	deliveryExpired
+)
+
+const (
+	deliveryInterval = time.Minute
+	// deliveryExpiry is how long a reply may stay pending.
+	deliveryExpiry = 24 * time.Hour
+	// deliveryWindow is the period the delivery rate is calculated over.
+	deliveryWindow = 24 * time.Hour
+)
+
+// Deliveries tracks delivery statuses of the replies by polling the sender,
+// statuses of the replies relayed to sc-client modems are reported by them.
+type Deliveries struct {
+	db      *gorm.DB
+	checker sender.StatusChecker
+}
+
+// NewDeliveries creates a tracker, replies sent by the sender are left
+// untracked if it can't tell delivery statuses.
+func NewDeliveries(db *gorm.DB, s sender.SmsSender) *Deliveries {
+	checker, _ := s.(sender.StatusChecker)
+	return &Deliveries{db: db, checker: checker}
+}
+
+// Sent marks the request reply as sent with the provider message ID.
+func (d *Deliveries) Sent(req *Request, id string) {
+	req.MessageId = id
+	req.SentAt = time.Now()
+	if d.checker != nil && len(id) > 0 || misc.IsModemMessageId(id) {
+		req.DeliveryStatus = deliveryPending
+	}
+}
+
+// Poll updates statuses of the pending replies, the ones pending
+// for too long are expired.
+func (d *Deliveries) Poll() (err error) {
+	var reqs []*Request
+	if err = d.db.Where("delivery_status = ?", deliveryPending).Find(&reqs).Error; err != nil {
+		return
+	}
+	now := time.Now()
+	for _, req := range reqs {
+		// relayed replies are reported, they're only expired here
+		status := sender.StatusPending
+		if d.checker != nil && !misc.IsModemMessageId(req.MessageId) {
+			if status, err = d.checker.Status(req.MessageId); err != nil {
+				log.Printf("deliveries: unable to get status of %s: %s", req.MessageId, err.Error())
+				continue
+			}
+		}
+		if !settle(req, status, now) {
+			continue
+		}
+		if err = d.db.Save(req).Error; err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Report updates the status of the relayed reply by the modem report.
+// References are reused, so the latest pending reply with it is taken.
+func (d *Deliveries) Report(r *misc.DeliveryReport) error {
+	id := misc.ModemMessageId(r.Origin, r.Reference)
+	var req Request
+	if err := d.db.Where("message_id = ? AND delivery_status = ?", id, deliveryPending).
+		Order("sent_at desc").First(&req).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if !settle(&req, sender.ReportStatus(r.Status), time.Now()) {
+		return nil
+	}
+	return d.db.Save(&req).Error
+}
+
+// HandleMessage handles the delivery reports of sc-client modems.
+func (d *Deliveries) HandleMessage(nmsg *nsq.Message) error {
+	var report misc.DeliveryReport
+	if err := json.Unmarshal(nmsg.Body, &report); err != nil {
+		return err
+	}
+	return d.Report(&report)
+}
+
+// settle sets the final delivery status of the reply, if there's one,
+// pending for too long is expired.
+func settle(req *Request, status sender.Status, now time.Time) bool {
+	switch status {
+	case sender.StatusDelivered:
+		req.DeliveryStatus = deliveryDone
+	case sender.StatusFailed:
+		req.DeliveryStatus = deliveryFailed
+	case sender.StatusExpired:
+		req.DeliveryStatus = deliveryExpired
+	default:
+		if now.Sub(req.SentAt) < deliveryExpiry {
+			return false
+		}
+		req.DeliveryStatus = deliveryExpired
+	}
+	req.DeliveryAt = now
+	return true
+}
+
+// Rate returns the percentage of delivered replies among the ones
+// that got the final status within the window.
+func (d *Deliveries) Rate(window time.Duration) (rate float64, total int, err error) {
+	since := time.Now().Add(-window)
+	var done int
+	if err = d.db.Model(&Request{}).Where("delivery_status = ? AND delivery_at > ?", deliveryDone, since).
+		Count(&done).Error; err != nil {
+		return
+	}
+	if err = d.db.Model(&Request{}).Where("delivery_status > ? AND delivery_at > ?", deliveryPending, since).
+		Count(&total).Error; err != nil {
+		return
+	}
+	if total < 1 {
+		return 0, 0, nil
+	}
+	return 100 * float64(done) / float64(total), total, nil
+}
+
+// runDeliveries polls delivery statuses every minute and publishes the rate.
+func (m *MessageHandler) runDeliveries() {
+	t := time.NewTicker(deliveryInterval)
+	defer t.Stop()
+	for range t.C {
+		if err := m.deliveries.Poll(); err != nil {
+			log.Println("deliveries: unable to poll:", err)
+			m.notifyError()
+			continue
+		}
+		rate, total, err := m.deliveries.Rate(deliveryWindow)
+		if err != nil {
+			log.Println("deliveries: unable to get rate:", err)
+			continue
+		}
+		if total > 0 {
+			m.notifyDelivery(rate)
+		}
+	}
+}
+
+func (m *MessageHandler) notifyDelivery(rate float64) (err error) {
+	n := misc.Notification{
+		Kind:  misc.NotifyDelivery,
+		Value: fmt.Sprintf("%.1f", rate),
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if err = m.stats.Publish(pubTopic, body); err != nil {
+		log.Println(err)
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/misc"
+	"github.com/xlab/smscloud/sender"
+)
+
+// testSender is a sender that tells delivery statuses by message ID.
+type testSender struct {
+	statuses map[string]sender.Status
+}
+
+func (s *testSender) Cost(to, text string) (float32, int, error) { return 1, 1, nil }
+func (s *testSender) Send(to, text string) (string, error)       { return "1", nil }
+func (s *testSender) Balance() (float32, error)                  { return 100, nil }
+
+func (s *testSender) Status(id string) (sender.Status, error) {
+	return s.statuses[id], nil
+}
+
+// untrackedSender can't tell delivery statuses.
+type untrackedSender struct{}
+
+func (untrackedSender) Cost(to, text string) (float32, int, error) { return 1, 1, nil }
+func (untrackedSender) Send(to, text string) (string, error)       { return "", nil }
+func (untrackedSender) Balance() (float32, error)                  { return 100, nil }
+
+func TestDeliveriesSent(t *testing.T) {
+	tests := []struct {
+		s      sender.SmsSender
+		id     string
+		status int8
+	}{
+		{&testSender{}, "42", deliveryPending},
+		{&testSender{}, "", deliveryUntracked},
+		{untrackedSender{}, "42", deliveryUntracked},
+		{untrackedSender{}, "modem1:42", deliveryPending}, // reported by the modem
+	}
+	for _, tt := range tests {
+		req := &Request{}
+		NewDeliveries(nil, tt.s).Sent(req, tt.id)
+		assert.Equal(t, tt.id, req.MessageId)
+		assert.Equal(t, tt.status, req.DeliveryStatus, "%T %q", tt.s, tt.id)
+		assert.WithinDuration(t, time.Now(), req.SentAt, time.Second)
+	}
+}
+
+func TestDeliveriesPoll(t *testing.T) {
+	db := openTestDB(t, Request{})
+	s := &testSender{statuses: map[string]sender.Status{
+		"1": sender.StatusDelivered,
+		"2": sender.StatusDelivered,
+		"3": sender.StatusFailed,
+		"4": sender.StatusExpired,
+		"5": sender.StatusPending,
+		"6": sender.StatusPending,
+	}}
+	d := NewDeliveries(db, s)
+	now := time.Now()
+	ids := make(map[string]int64)
+	for id := range s.statuses {
+		req := Request{Address: "+7999", Timestamp: now}
+		d.Sent(&req, id)
+		if id == "6" {
+			req.SentAt = now.Add(-deliveryExpiry - time.Minute)
+		}
+		if err := db.Create(&req).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = req.Id
+	}
+	if !assert.NoError(t, d.Poll()) {
+		return
+	}
+	want := map[string]int8{
+		"1": deliveryDone,
+		"2": deliveryDone,
+		"3": deliveryFailed,
+		"4": deliveryExpired,
+		"5": deliveryPending,
+		"6": deliveryExpired,
+	}
+	for id, status := range want {
+		var req Request
+		if assert.NoError(t, db.First(&req, ids[id]).Error) {
+			assert.Equal(t, status, req.DeliveryStatus, id)
+		}
+	}
+	rate, total, err := d.Rate(deliveryWindow)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, 40.0, rate)
+}
+
+func TestDeliveriesReport(t *testing.T) {
+	db := openTestDB(t, Request{})
+	// relayed replies aren't polled even if the backend can tell statuses
+	d := NewDeliveries(db, &testSender{statuses: map[string]sender.Status{
+		"modem1:42": sender.StatusFailed,
+	}})
+	now := time.Now()
+	sent := func(sentAt time.Time) int64 {
+		req := Request{Address: "+7999", Timestamp: now}
+		d.Sent(&req, "modem1:42")
+		req.SentAt = sentAt
+		if err := db.Create(&req).Error; err != nil {
+			t.Fatal(err)
+		}
+		return req.Id
+	}
+	// the reference has been used before
+	old, last := sent(now.Add(-time.Hour)), sent(now)
+	status := func(id int64) int8 {
+		var req Request
+		if err := db.First(&req, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return req.DeliveryStatus
+	}
+	report := func(st byte) *nsq.Message {
+		body, _ := json.Marshal(misc.DeliveryReport{Origin: "modem1", Reference: 42, Status: st})
+		return &nsq.Message{Body: body}
+	}
+	assert.NoError(t, d.Poll())
+	assert.Equal(t, deliveryPending, status(last))
+
+	// the service centre is still trying
+	assert.NoError(t, d.HandleMessage(report(0x30)))
+	assert.Equal(t, deliveryPending, status(last))
+
+	assert.NoError(t, d.HandleMessage(report(0x00)))
+	assert.Equal(t, deliveryDone, status(last))
+	assert.Equal(t, deliveryPending, status(old))
+
+	rate, total, err := d.Rate(deliveryWindow)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 100.0, rate)
+
+	assert.Error(t, d.HandleMessage(&nsq.Message{Body: []byte("{")}))
+}
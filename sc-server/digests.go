@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xlab/smscloud/currency"
+	"github.com/xlab/smscloud/wikipedia"
+)
+
+var digestRates = []string{"USD", "EUR", "CNY"}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// Digest builds the text sent to subscribers of a topic.
+type Digest struct {
+	SendAt string // default daily clock time
+	// Build returns the raw text, it's sanitized and cut on dispatch.
+	Build func(ctx context.Context) (string, error)
+}
+
+func newDigests(client *http.Client) map[string]*Digest {
+	rates := currency.NewApi()
+	rates.Client = client
+	wiki := wikipedia.NewApi()
+	wiki.Client = client
+	return map[string]*Digest{
+		"rates": {
+			SendAt: "09:00",
+			Build: func(ctx context.Context) (string, error) {
+				return ratesDigest(ctx, rates)
+			},
+		},
+		"wiki-featured": {
+			SendAt: "10:00",
+			Build: func(ctx context.Context) (string, error) {
+				return featuredDigest(ctx, wiki)
+			},
+		},
+	}
+}
+
+func ratesDigest(ctx context.Context, a *currency.Api) (string, error) {
+	res, err := a.Query(ctx)
+	if err != nil {
+		return "", err
+	}
+	items := make([]string, 0, len(digestRates))
+	for _, code := range digestRates {
+		if v := res.Find(code); v != nil {
+			items = append(items, code+" "+strconv.FormatFloat(v.UnitRate(), 'f', 2, 64))
+		}
+	}
+	return fmt.Sprintf("%s (CBR %s)", strings.Join(items, ", "), res.Date), nil
+}
+
+func featuredDigest(ctx context.Context, a *wikipedia.Api) (string, error) {
+	res, err := a.Featured(ctx, wikipedia.EN)
+	if err != nil {
+		return "", err
+	}
+	it := res.Latest()
+	desc := html.UnescapeString(htmlTag.ReplaceAllString(it.Description, " "))
+	return it.Title + ": " + desc, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"text/template"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Classes of errors the sender is told about.
+const (
+	errNoAnswer     = "no_answer"
+	errUpstreamDown = "upstream_down"
+	errOverBudget   = "over_budget"
+	errThrottled    = "throttled"
+	errInternal     = "internal"
+)
+
+// Policies of notifying the sender.
+const (
+	notifyAlways = "always"
+	notifyNever  = "never"
+	// notifyOnce sends at most one reply of the class per window.
+	notifyOnce = "once"
+)
+
+var ErrPolicy = errors.New("sc-server: unknown error reply policy")
+
+type errorReply struct {
+	// Template is a text/template with the .Time, .Service and .Link fields.
+	Template string `json:"template"`
+	Policy   string `json:"policy"`
+	// Window is the notifyOnce period in seconds.
+	Window int `json:"window"`
+}
+
+// errorsConfig holds error replies by class.
+type errorsConfig map[string]*errorReply
+
+func (e errorsConfig) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &e)
+}
+
+// ErrorNotice is the last error reply of the class sent to the address.
+type ErrorNotice struct {
+	Id      int64
+	Address string `sql:"size:20"`
+	Class   string `sql:"size:20"`
+	SentAt  time.Time
+}
+
+type errorTemplate struct {
+	tpl    *template.Template
+	policy string
+	window time.Duration
+}
+
+// ErrorReplies renders error replies and decides whether the sender
+// is notified according to the class policy. Classes that aren't
+// configured are never replied.
+type ErrorReplies struct {
+	db        *gorm.DB
+	templates map[string]*errorTemplate
+}
+
+func NewErrorReplies(db *gorm.DB, cfg errorsConfig) (e *ErrorReplies, err error) {
+	if err = db.AutoMigrate(ErrorNotice{}).Error; err != nil {
+		return nil, err
+	}
+	e = &ErrorReplies{db: db}
+	if e.templates, err = parseErrorReplies(cfg); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func parseErrorReplies(cfg errorsConfig) (templates map[string]*errorTemplate, err error) {
+	templates = make(map[string]*errorTemplate, len(cfg))
+	for class, reply := range cfg {
+		switch reply.Policy {
+		case notifyAlways, notifyNever, notifyOnce:
+		default:
+			return nil, ErrPolicy
+		}
+		t := &errorTemplate{
+			policy: reply.Policy,
+			window: time.Duration(reply.Window) * time.Second,
+		}
+		if t.tpl, err = template.New(class).Parse(reply.Template); err != nil {
+			return nil, err
+		}
+		templates[class] = t
+	}
+	return
+}
+
+// Reply returns the error reply to the request, ok is false
+// if the sender shouldn't be notified now. Replies of the once
+// policy count once they're marked Sent.
+func (e *ErrorReplies) Reply(req *Request, class string) (text string, ok bool, err error) {
+	t, known := e.templates[class]
+	if !known || t.policy == notifyNever {
+		return "", false, nil
+	}
+	if t.policy == notifyOnce {
+		if ok, err = e.due(req.Address, class, t.window); err != nil || !ok {
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if err = t.tpl.Execute(&buf, struct {
+		Time, Service, Link string
+	}{
+		Time:    req.OpTimestamp.Format(`2 Jan 15:04`),
+		Service: req.Service,
+		Link:    req.ShortUrl,
+	}); err != nil {
+		return "", false, err
+	}
+	return string(bytes.TrimSpace(buf.Bytes())), true, nil
+}
+
+// due reports whether the window has passed since the last reply
+// of the class to the address.
+func (e *ErrorReplies) due(addr, class string, window time.Duration) (ok bool, err error) {
+	var notice ErrorNotice
+	if err = e.db.Where("address = ? AND class = ?", addr, class).First(&notice).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return true, nil
+		}
+		return
+	}
+	return time.Since(notice.SentAt) >= window, nil
+}
+
+// Sent records the reply of the class to the address,
+// only the once policy needs it.
+func (e *ErrorReplies) Sent(addr, class string) (err error) {
+	if t, known := e.templates[class]; !known || t.policy != notifyOnce {
+		return nil
+	}
+	var notice ErrorNotice
+	if err = e.db.Where("address = ? AND class = ?", addr, class).First(&notice).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			return
+		}
+		notice = ErrorNotice{Address: addr, Class: class}
+	}
+	notice.SentAt = time.Now()
+	return e.db.Save(&notice).Error
+}
+
+// classify returns the class of the error the route has failed with.
+// Services that fail to answer have no answer unless they're unreachable.
+// Replies over budget aren't errors, they're deferred by the outbox.
+func classify(route string, err error) string {
+	switch err.(type) {
+	case net.Error, *json.SyntaxError, *xml.SyntaxError:
+		return errUpstreamDown
+	}
+	if err == context.DeadlineExceeded {
+		return errUpstreamDown
+	}
+	if builtinRoutes[route] {
+		return errInternal
+	}
+	return errNoAnswer
+}
+
+// sendError replies to the request with the error class template
+// if the class policy allows notifying the sender now. The over budget
+// notice isn't checked against the budget, it would be deferred too.
+func (m *MessageHandler) sendError(req *Request, class string) error {
+	text, ok, err := m.errReplies.Reply(req, class)
+	if err != nil || !ok {
+		return err
+	}
+	log.Printf("%s error reply to request %d", class, req.Id)
+	text = transliterate(text, req.Translit)
+	if _, _, err = m.outbox.SendNotice(req, text, class == errOverBudget); err != nil {
+		return err
+	}
+	// stored replies are retried, so the notice counts
+	if err = m.errReplies.Sent(req.Address, class); err != nil {
+		log.Printf("error recording %s reply to %s: %s", class, req.Address, err.Error())
+	}
+	return nil
+}
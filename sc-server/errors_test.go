@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testErrors = errorsConfig{
+	errNoAnswer: {
+		Template: "No answer to {{.Time}} {{.Link}}",
+		Policy:   notifyAlways,
+	},
+	errUpstreamDown: {
+		Template: "{{.Service}} is down",
+		Policy:   notifyOnce,
+		Window:   3600,
+	},
+	errOverBudget: {
+		Template: "Later",
+		Policy:   notifyNever,
+	},
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		route string
+		err   error
+		class string
+	}{
+		{"wolfram", errors.New("nothing found"), errNoAnswer},
+		{"wolfram", &net.OpError{Op: "dial", Err: errors.New("refused")}, errUpstreamDown},
+		{"wikipedia", &json.SyntaxError{}, errUpstreamDown},
+		{"wikipedia", context.DeadlineExceeded, errUpstreamDown},
+		{remindRoute, errors.New("db is down"), errInternal},
+		{moreRoute, &net.OpError{Op: "read", Err: errors.New("reset")}, errUpstreamDown},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.class, classify(tt.route, tt.err), "%s: %v", tt.route, tt.err)
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	templates, err := parseErrorReplies(testErrors)
+	if !assert.NoError(t, err) {
+		return
+	}
+	e := &ErrorReplies{templates: templates}
+	req := &Request{
+		Service:     "wolfram",
+		ShortUrl:    "http://goo.gl/x",
+		OpTimestamp: time.Date(2015, 3, 10, 18, 30, 0, 0, time.UTC),
+	}
+	text, ok, err := e.Reply(req, errNoAnswer)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "No answer to 10 Mar 18:30 http://goo.gl/x", text)
+
+	for _, class := range []string{errOverBudget, errThrottled} {
+		_, ok, err = e.Reply(req, class)
+		assert.NoError(t, err)
+		assert.False(t, ok, class)
+	}
+	// nothing to record for these policies
+	assert.NoError(t, e.Sent(req.Address, errNoAnswer))
+	assert.NoError(t, e.Sent(req.Address, errThrottled))
+
+	_, err = parseErrorReplies(errorsConfig{errInternal: {Policy: "sometimes"}})
+	assert.Equal(t, ErrPolicy, err)
+	_, err = parseErrorReplies(errorsConfig{errInternal: {Template: "{{", Policy: notifyAlways}})
+	assert.Error(t, err)
+}
+
+func TestErrorReplyOnce(t *testing.T) {
+	db := openTestDB(t, ErrorNotice{})
+	e, err := NewErrorReplies(db, testErrors)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req := &Request{Address: "+79991234567", Service: "wolfram"}
+	text, ok, err := e.Reply(req, errUpstreamDown)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "wolfram is down", text)
+	// not sent, so still due
+	_, ok, err = e.Reply(req, errUpstreamDown)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, e.Sent(req.Address, errUpstreamDown))
+	_, ok, err = e.Reply(req, errUpstreamDown)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = e.Reply(&Request{Address: "+79990000000"}, errUpstreamDown)
+	assert.NoError(t, err)
+	assert.True(t, ok, "another sender")
+
+	// the window has passed
+	assert.NoError(t, db.Model(&ErrorNotice{}).Where("address = ?", req.Address).
+		Update("sent_at", time.Now().Add(-2*time.Hour)).Error)
+	_, ok, err = e.Reply(req, errUpstreamDown)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
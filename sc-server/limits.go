@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Reasons a message is throttled for.
+const (
+	limitDenied = "denied"
+	limitBurst  = "burst"
+	limitHour   = "hourly cap"
+	limitDay    = "daily cap"
+	limitFlood  = "flood"
+)
+
+type limitsConfig struct {
+	// Burst is how many requests a sender may make within BurstWindow seconds.
+	Burst       int `json:"burst"`
+	BurstWindow int `json:"burst_window"`
+	PerHour     int `json:"per_hour"`
+	PerDay      int `json:"per_day"`
+	// FloodLimit is how many identical texts all senders together may send
+	// within FloodWindow seconds. Bare keywords and choices aren't counted.
+	FloodLimit  int `json:"flood_limit"`
+	FloodWindow int `json:"flood_window"`
+	// Allow lists senders that are never throttled,
+	// Deny lists senders whose messages are dropped silently.
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+func (l *limitsConfig) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, l)
+}
+
+// Limits throttles senders by their stored requests, so the limits
+// survive restarts. Zero limits are disabled.
+type Limits struct {
+	cfg *limitsConfig
+	db  *gorm.DB
+	// common reports texts many senders send alike, they're not floods.
+	common func(text string) bool
+	allow  map[string]bool
+	deny   map[string]bool
+}
+
+func NewLimits(db *gorm.DB, cfg *limitsConfig, common func(text string) bool) (l *Limits, err error) {
+	// the limits are counted on every message
+	if err = db.Model(Request{}).AddIndex("idx_requests_address_timestamp", "address", "timestamp").Error; err != nil {
+		return nil, err
+	}
+	if err = db.Model(Request{}).AddIndex("idx_requests_text_timestamp", "text", "timestamp").Error; err != nil {
+		return nil, err
+	}
+	l = &Limits{
+		cfg:    cfg,
+		db:     db,
+		common: common,
+		allow:  make(map[string]bool),
+		deny:   make(map[string]bool),
+	}
+	for _, addr := range cfg.Allow {
+		l.allow[addr] = true
+	}
+	for _, addr := range cfg.Deny {
+		l.deny[addr] = true
+	}
+	return l, nil
+}
+
+// Check returns the reason the stored request is throttled for, empty if it's not.
+// Throttled requests don't count against the limits.
+func (l *Limits) Check(req *Request) (reason string, err error) {
+	switch {
+	case l.deny[req.Address]:
+		return limitDenied, nil
+	case l.allow[req.Address]:
+		return "", nil
+	}
+	now := time.Now()
+	limits := []struct {
+		reason string
+		max    int
+		window time.Duration
+	}{
+		{limitBurst, l.cfg.Burst, time.Duration(l.cfg.BurstWindow) * time.Second},
+		{limitHour, l.cfg.PerHour, time.Hour},
+		{limitDay, l.cfg.PerDay, 24 * time.Hour},
+	}
+	for _, limit := range limits {
+		if limit.max < 1 || limit.window <= 0 {
+			continue
+		}
+		var n int
+		if err = l.db.Model(&Request{}).Where("address = ? AND request_status <> ? AND timestamp > ?",
+			req.Address, reqThrottled, now.Add(-limit.window)).Count(&n).Error; err != nil {
+			return
+		}
+		if n > limit.max {
+			return limit.reason, nil
+		}
+	}
+	if l.cfg.FloodLimit > 0 && l.cfg.FloodWindow > 0 && !l.common(req.Text) {
+		since := now.Add(-time.Duration(l.cfg.FloodWindow) * time.Second)
+		var n int
+		if err = l.db.Model(&Request{}).Where("text = ? AND request_status <> ? AND timestamp > ?",
+			req.Text, reqThrottled, since).Count(&n).Error; err != nil {
+			return
+		}
+		if n > l.cfg.FloodLimit {
+			return limitFlood, nil
+		}
+	}
+	return "", nil
+}
+
+// commonText reports whether the text is a bare keyword or a choice.
+func (m *MessageHandler) commonText(text string) bool {
+	if _, ok := parseChoice(text); ok {
+		return true
+	}
+	_, query := m.router.Route("", text)
+	return len(query) < 1
+}
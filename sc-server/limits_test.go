@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommonText(t *testing.T) {
+	r, err := NewRouter(testRoutes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	m := &MessageHandler{router: r}
+	tests := []struct {
+		text   string
+		common bool
+	}{
+		{"1", true},
+		{" 2 ", true},
+		{"more", true},
+		{"ЕЩЁ", true},
+		{"help", true},
+		{"wiki", true},
+		{"wiki Moscow", false},
+		{"free iphone", false},
+		{"100", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.common, m.commonText(tt.text), tt.text)
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	db := openTestDB(t, Request{})
+	cfg := &limitsConfig{
+		Burst:       3,
+		BurstWindow: 60,
+		PerHour:     5,
+		PerDay:      6,
+		FloodLimit:  3,
+		FloodWindow: 600,
+		Allow:       []string{"allowed"},
+		Deny:        []string{"denied"},
+	}
+	l, err := NewLimits(db, cfg, func(text string) bool {
+		return text == "more"
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Now()
+	store := func(addr, text string, ago time.Duration, status int8) {
+		req := Request{Address: addr, Text: text, Timestamp: now.Add(-ago), RequestStatus: status}
+		if err := db.Create(&req).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bursty: 4 within a minute
+	for i := 0; i < 4; i++ {
+		store("bursty", "q"+strconv.Itoa(i), time.Second, reqDone)
+	}
+	// hourly: 6 within an hour, 1 within a minute
+	for i := 0; i < 6; i++ {
+		store("hourly", "q"+strconv.Itoa(i), time.Duration(i)*10*time.Minute, reqDone)
+	}
+	// daily: 7 within a day, spread over hours
+	for i := 0; i < 7; i++ {
+		store("daily", "q"+strconv.Itoa(i), time.Duration(i)*3*time.Hour, reqDone)
+	}
+	// throttled requests don't count
+	for i := 0; i < 4; i++ {
+		store("calm", "q"+strconv.Itoa(i), time.Second, reqThrottled)
+	}
+	// the same text from everybody
+	for i := 0; i < 4; i++ {
+		store("flood"+strconv.Itoa(i), "free iphone", time.Minute, reqDone)
+		store("reader"+strconv.Itoa(i), "more", time.Minute, reqDone)
+	}
+	for i := 0; i < 4; i++ {
+		store("allowed", "a"+strconv.Itoa(i), time.Second, reqDone)
+	}
+	tests := []struct {
+		addr, text string
+		reason     string
+	}{
+		{"denied", "q", limitDenied},
+		{"allowed", "q", ""},
+		{"bursty", "q", limitBurst},
+		{"hourly", "q", limitHour},
+		{"daily", "q", limitDay},
+		{"calm", "q", ""},
+		{"flood0", "free iphone", limitFlood},
+		{"reader0", "more", ""},
+		{"nobody", "hello", ""},
+	}
+	for _, tt := range tests {
+		reason, err := l.Check(&Request{Address: tt.addr, Text: tt.text})
+		assert.NoError(t, err)
+		assert.Equal(t, tt.reason, reason, tt.addr)
+	}
+}
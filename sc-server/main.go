@@ -1,27 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/url"
 	"os"
 	"strconv"
-	"strings"
 	"time"
-	"unicode"
-	"github.com/jinzhu/gorm"
-	_ "github.com/lib/pq"
 
 	"github.com/bitly/go-nsq"
 	"github.com/codegangsta/cli"
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"
 	"github.com/xlab/smscloud/googl"
 	"github.com/xlab/smscloud/misc"
-	"github.com/xlab/smscloud/wikipedia"
-	"github.com/xlab/smscloud/wolfram"
-	"github.com/xlab/smsru"
+	"github.com/xlab/smscloud/sanitize"
+	"github.com/xlab/smscloud/sender"
+	"github.com/xlab/smscloud/service"
+	"github.com/xlab/smscloud/smsenc"
+	"github.com/xlab/smscloud/summary"
+	"github.com/xlab/smscloud/translit"
 )
 
 const (
@@ -31,25 +32,20 @@ const (
 )
 
 const (
-	latinSize = 306
-	cyrSize   = 134
-	urlLen    = 21
+	// defaultSegments is how many SMS a reply page may take
+	// unless the service sets its own maximum.
+	defaultSegments = 2
 )
 
 const (
 	reqPending int8 = iota
 	reqDone
 	reqError
+	reqThrottled
 )
 
 const approxSmsCost = 0.70
 const originCountry = "Russia"
-const wolframUserURI = "http://www.wolframalpha.com/input/"
-
-var supportedServices = map[string]bool{
-	"wolfram":   true,
-	"wikipedia": true,
-}
 
 var app = cli.NewApp()
 
@@ -79,6 +75,97 @@ func init() {
 			Value: "credentials.json",
 			Usage: "an API credentials config file",
 		},
+		cli.StringFlag{
+			Name:  "r,routes-cfg",
+			Value: "routes.json",
+			Usage: "a keyword routing config file",
+		},
+		cli.StringFlag{
+			Name:  "s,services-cfg",
+			Value: "services.json",
+			Usage: "a per-service settings config file",
+		},
+		cli.StringFlag{
+			Name:  "e,sender-cfg",
+			Value: "sender.json",
+			Usage: "an outgoing SMS backend config file",
+		},
+		cli.StringFlag{
+			Name:  "b,budget-cfg",
+			Value: "budget.json",
+			Usage: "a spend caps and reserve config file",
+		},
+		cli.StringFlag{
+			Name:  "a,limits-cfg",
+			Value: "limits.json",
+			Usage: "a per-sender rate limits config file",
+		},
+		cli.StringFlag{
+			Name:  "o,errors-cfg",
+			Value: "errors.json",
+			Usage: "an error replies templates and policies config file",
+		},
+		cli.StringFlag{
+			Name:  "z,sanitize-cfg",
+			Value: "sanitize.json",
+			Usage: "a reply sanitizer rules config file",
+		},
+		cli.IntFlag{
+			Name:  "t,timeout",
+			Value: 20,
+			Usage: "a service query timeout in seconds",
+		},
+		cli.BoolFlag{
+			Name:  "m,modem-replies",
+			Usage: "send replies through the sc-client modem that received the request",
+		},
+		cli.StringFlag{
+			Name:  "l,translit",
+			Usage: "a default transliteration of Cyrillic replies: gost or icao",
+		},
+		cli.StringFlag{
+			Name:  "tz,timezone",
+			Value: "Local",
+			Usage: "a timezone clock times of reminders and digests are read in, e.g. Europe/Moscow",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "purge-cache",
+			Usage: "drops cached replies, optionally of a service or a single query",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "s,service",
+					Usage: "a service to purge replies of",
+				},
+				cli.BoolFlag{
+					Name:  "expired",
+					Usage: "purge only expired replies",
+				},
+			},
+			Action: purgeCache,
+		},
+		{
+			Name:  "sanitize",
+			Usage: "dry-runs the sanitizer over stored replies and prints the changed ones",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "s,service",
+					Usage: "a service to take replies of",
+				},
+				cli.IntFlag{
+					Name:  "n,limit",
+					Value: 20,
+					Usage: "a number of the latest replies to take",
+				},
+			},
+			Action: dryRunSanitize,
+		},
+		{
+			Name:   "requeue",
+			Usage:  "requeues dead letters of the outbox, all of them if no IDs are given",
+			Action: requeueDeadLetters,
+		},
 	}
 }
 
@@ -91,17 +178,54 @@ func main() {
 		cfg.UserAgent = "sc-server/0.1"
 
 		hCfg := &handlerConfig{
-			NsqAddr:     c.String("nsqd"),
-			NsqCfg:      cfg,
-			DbCfg:       &dbConfig{},
-			Credentials: &credConfig{},
+			NsqAddr:      c.String("nsqd"),
+			NsqCfg:       cfg,
+			DbCfg:        &dbConfig{},
+			Credentials:  &credConfig{},
+			Routes:       &routesConfig{},
+			Services:     servicesConfig{},
+			Sanitize:     &sanitize.Config{},
+			Sender:       &sender.Config{},
+			Budget:       &budgetConfig{},
+			Limits:       &limitsConfig{},
+			Errors:       errorsConfig{},
+			Timeout:      time.Duration(c.Int("timeout")) * time.Second,
+			Translit:     c.String("translit"),
+			ModemReplies: c.Bool("modem-replies"),
 		}
+		loc, err := time.LoadLocation(c.String("timezone"))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		hCfg.Location = loc
 		if err := hCfg.DbCfg.ReadFromFile(c.String("db-cfg")); err != nil {
 			log.Fatalln(err)
 		}
 		if err := hCfg.Credentials.ReadFromFile(c.String("cred-cfg")); err != nil {
 			log.Fatalln(err)
 		}
+		if err := hCfg.Routes.ReadFromFile(c.String("routes-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		if err := hCfg.Services.ReadFromFile(c.String("services-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		if err := hCfg.Sanitize.ReadFromFile(c.String("sanitize-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		if err := hCfg.Sender.ReadFromFile(c.String("sender-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		if err := hCfg.Budget.ReadFromFile(c.String("budget-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		if err := hCfg.Limits.ReadFromFile(c.String("limits-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		if err := hCfg.Errors.ReadFromFile(c.String("errors-cfg")); err != nil {
+			log.Fatalln(err)
+		}
+		hCfg.Sender.SmsruKey = hCfg.Credentials.SmsruPrivateKey
 		handler, err := NewMessageHandler(hCfg)
 		if err != nil {
 			log.Fatalln(err)
@@ -110,6 +234,30 @@ func main() {
 		if err != nil {
 			log.Fatalln(err)
 		}
+		go handler.scheduler.Run()
+		go handler.runDigests()
+		go handler.runDeliveries()
+		go handler.outbox.Run()
+		if handler.relay != nil {
+			reports, err := nsq.NewConsumer(misc.ReportTopic, reportChannel, cfg)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			reports.AddHandler(handler.relay)
+			reports.SetLogger(nsqLog, nsq.LogLevelDebug)
+			if err = reports.ConnectToNSQD(c.String("nsqd")); err != nil {
+				log.Fatalln(err)
+			}
+			deliveries, err := nsq.NewConsumer(misc.DeliveryTopic, reportChannel, cfg)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			deliveries.AddHandler(handler.deliveries)
+			deliveries.SetLogger(nsqLog, nsq.LogLevelDebug)
+			if err = deliveries.ConnectToNSQD(c.String("nsqd")); err != nil {
+				log.Fatalln(err)
+			}
+		}
 		consumer.AddHandler(handler)
 		consumer.SetLogger(nsqLog, nsq.LogLevelDebug)
 		if err = consumer.ConnectToNSQD(c.String("nsqd")); err != nil {
@@ -127,6 +275,19 @@ type credConfig struct {
 	GoogleClientEmail string `json:"google_client_email"`
 	WolframPrivateKey string `json:"wolfram_private_key"`
 	SmsruPrivateKey   string `json:"smsru_private_key"`
+	// ServiceKeys holds API keys for the registered services by name.
+	ServiceKeys map[string]string `json:"service_keys"`
+}
+
+// ServiceKey returns an API key for the named service.
+func (c *credConfig) ServiceKey(name string) string {
+	if key, ok := c.ServiceKeys[name]; ok {
+		return key
+	}
+	if name == "wolfram" {
+		return c.WolframPrivateKey
+	}
+	return ""
 }
 
 func (c *credConfig) ReadFromFile(name string) error {
@@ -157,39 +318,141 @@ func (d *dbConfig) DataSourceName() string {
 	return fmt.Sprintf(connectStr, d.User, d.Password, d.Addr, d.DBName)
 }
 
+// servicesConfig holds settings of the registered services by name.
+type servicesConfig map[string]*serviceSettings
+
+type serviceSettings struct {
+	// Languages is the content languages fallback order.
+	Languages []string `json:"languages"`
+	// BaseURL overrides the service API endpoint.
+	BaseURL string `json:"base_url"`
+	// CacheTTL is how long replies are cached in seconds,
+	// zero means default and a negative value disables caching.
+	CacheTTL int `json:"cache_ttl"`
+	// MaxSegments is how many concatenated SMS a reply page may take.
+	MaxSegments int `json:"max_segments"`
+}
+
+func (s servicesConfig) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s)
+}
+
+// Settings returns settings of the named service, defaults if not set.
+func (s servicesConfig) Settings(name string) *serviceSettings {
+	if settings, ok := s[name]; ok && settings != nil {
+		return settings
+	}
+	return &serviceSettings{}
+}
+
 type handlerConfig struct {
 	NsqAddr     string
 	NsqCfg      *nsq.Config
 	DbCfg       *dbConfig
 	Credentials *credConfig
+	Routes      *routesConfig
+	Services    servicesConfig
+	Sanitize    *sanitize.Config
+	Sender      *sender.Config
+	Budget      *budgetConfig
+	Limits      *limitsConfig
+	Errors      errorsConfig
+	Timeout     time.Duration
+	Translit    string
+	// Location is the timezone clock times sent by users are read in.
+	Location *time.Location
+	// ModemReplies enables sending replies through the receiving modems.
+	ModemReplies bool
 }
 
 type MessageHandler struct {
-	stats    *nsq.Producer
-	db       gorm.DB
-	wolfApi  *wolfram.Api
-	wikiApi  *wikipedia.Api
-	googlApi *googl.Shortener
-	smsApi   *smsru.Api
+	stats      *nsq.Producer
+	db         gorm.DB
+	services   map[string]service.Service
+	segments   map[string]int
+	router     *Router
+	scheduler  *Scheduler
+	reminders  *Reminders
+	subs       *Subscriptions
+	pager      *Pager
+	choices    *Choices
+	cache      *ReplyCache
+	sanitizer  *sanitize.Pipeline
+	translits  *Translits
+	timeout    time.Duration
+	googlApi   *googl.Shortener
+	sender     sender.SmsSender
+	relay      *Relay
+	deliveries *Deliveries
+	outbox     *Outbox
+	budget     *Budget
+	limits     *Limits
+	errReplies *ErrorReplies
 }
 
 type Request struct {
 	Id            int64
 	Text          string `sql:"size:160"`
 	Address       string `sql:"size:20"`
+	Origin        string `sql:"size:20"`
 	Service       string `sql:"size:20"`
 	ServiceReply  string
 	ShortUrl      string `sql:"size:20"`
 	RequestStatus int8
+	CacheHit      bool
 	Timestamp     time.Time
 	OpTimestamp   time.Time
+	// MessageId is the provider ID of the reply, its delivery is tracked.
+	MessageId      string `sql:"size:40"`
+	DeliveryStatus int8
+	SentAt         time.Time
+	DeliveryAt     time.Time
+	// Page is the part of ServiceReply to be sent, the whole reply if empty.
+	Page string `sql:"-"`
+	// Segments limits the reply size, the service maximum is used if zero.
+	Segments int `sql:"-"`
+	// Translit is the sender's transliteration table, nil if none.
+	Translit *translit.Table `sql:"-"`
 }
 
 func NewMessageHandler(cfg *handlerConfig) (h *MessageHandler, err error) {
 	h = &MessageHandler{
-		wikiApi: wikipedia.NewApi(),
-		wolfApi: wolfram.NewApi(cfg.Credentials.WolframPrivateKey, originCountry),
-		smsApi:  smsru.NewApi(cfg.Credentials.SmsruPrivateKey),
+		services: make(map[string]service.Service),
+		segments: make(map[string]int),
+		timeout:  cfg.Timeout,
+	}
+	if h.timeout <= 0 {
+		h.timeout = misc.DefaultHTTPTimeout
+	}
+	client := misc.NewHTTPClient(h.timeout)
+	ttls := make(map[string]time.Duration)
+	if h.router, err = NewRouter(cfg.Routes); err != nil {
+		return nil, err
+	}
+	if h.sanitizer, err = sanitize.New(cfg.Sanitize); err != nil {
+		return nil, err
+	}
+	if h.sender, err = sender.New(cfg.Sender); err != nil {
+		return nil, err
+	}
+	for _, name := range service.Names() {
+		settings := cfg.Services.Settings(name)
+		ttls[name] = time.Duration(settings.CacheTTL) * time.Second
+		h.segments[name] = settings.MaxSegments
+		svcCfg := &service.Config{
+			Key:       cfg.Credentials.ServiceKey(name),
+			Location:  originCountry,
+			Languages: settings.Languages,
+			Client:    client,
+			BaseURL:   settings.BaseURL,
+		}
+		if h.services[name], err = service.New(name, svcCfg); err != nil {
+			return nil, err
+		}
 	}
 	if h.googlApi, err = googl.NewShortener(
 		cfg.Credentials.GoogleClientEmail,
@@ -203,9 +466,48 @@ func NewMessageHandler(cfg *handlerConfig) (h *MessageHandler, err error) {
 	if err = h.db.AutoMigrate(Request{}).Error; err != nil {
 		return nil, err
 	}
+	if h.scheduler, err = NewScheduler(&h.db, h.sendReminder); err != nil {
+		return nil, err
+	}
+	if h.subs, err = NewSubscriptions(&h.db, newDigests(client), cfg.Location); err != nil {
+		return nil, err
+	}
+	if h.translits, err = NewTranslits(&h.db, cfg.Translit); err != nil {
+		return nil, err
+	}
+	if h.pager, err = NewPager(&h.db, h.maxSegments); err != nil {
+		return nil, err
+	}
+	if h.choices, err = NewChoices(&h.db); err != nil {
+		return nil, err
+	}
+	if h.cache, err = NewReplyCache(&h.db, ttls); err != nil {
+		return nil, err
+	}
+	h.deliveries = NewDeliveries(&h.db, h.sender)
+	if h.budget, err = NewBudget(&h.db, cfg.Budget, h.notifyBudget); err != nil {
+		return nil, err
+	}
+	// the reserve is enforced from the first send
+	if _, err := h.getReserve(); err != nil {
+		log.Println("budget: unable to get balance:", err)
+	}
+	if h.limits, err = NewLimits(&h.db, cfg.Limits, h.commonText); err != nil {
+		return nil, err
+	}
+	if h.errReplies, err = NewErrorReplies(&h.db, cfg.Errors); err != nil {
+		return nil, err
+	}
+	h.reminders = &Reminders{sched: h.scheduler, loc: cfg.Location}
 	if h.stats, err = nsq.NewProducer(cfg.NsqAddr, cfg.NsqCfg); err != nil {
 		return nil, err
 	}
+	if h.outbox, err = NewOutbox(&h.db, h.stats, h.sendOutbox, h.replySent, h.deadLetter); err != nil {
+		return nil, err
+	}
+	if cfg.ModemReplies {
+		h.relay = NewRelay(h.stats, h.outbox.Relayed, h.outbox.RelayFailed)
+	}
 	return
 }
 
@@ -214,17 +516,21 @@ func (m *MessageHandler) HandleMessage(nmsg *nsq.Message) (err error) {
 	if err = json.Unmarshal(nmsg.Body, &msg); err != nil {
 		return
 	}
-	if !supportedServices[msg.Origin] {
+	name, query, chosen := m.route(&msg)
+	svc, ok := m.services[name]
+	if !ok && !builtinRoutes[name] {
 		nmsg.Finish()
-		return errors.New("message for unsupported service: " + msg.Origin)
+		return errors.New("message for unsupported service: " + name)
 	}
 	req := Request{
 		Text:          msg.Text,
 		Address:       msg.Address,
-		Service:       msg.Origin,
+		Origin:        msg.Origin,
+		Service:       name,
 		RequestStatus: reqPending,
 		Timestamp:     time.Time(msg.Timestamp),
 		OpTimestamp:   time.Time(msg.OpTimestamp),
+		Translit:      m.translits.For(msg.Address),
 	}
 	if err = m.db.Create(&req).Error; err != nil {
 		log.Println("error storing message:", err)
@@ -240,48 +546,58 @@ func (m *MessageHandler) HandleMessage(nmsg *nsq.Message) (err error) {
 			m.notifyError()
 		}
 	}(&req)
-	switch msg.Origin {
-	case "wolfram":
-		reply, err := m.queryWolfram(msg.Text)
-		if err != nil {
-			req.RequestStatus = reqError
-			log.Printf("error querying %x: %s", msg.UUID, err.Error())
-			m.notifyError()
-			return nil
-		}
-		if short, err := m.googlApi.Short(wolframURL(msg.Text)); err != nil {
-			log.Printf("error shorting url for %x: %s", msg.UUID, err.Error())
+	if m.throttle(&req) {
+		return nil
+	}
+	if chosen {
+		// a throttled choice can be repeated later
+		if err = m.choices.Drop(msg.Address); err != nil {
+			log.Printf("error dropping choice for %x: %s", msg.UUID, err.Error())
 			m.notifyError()
-		} else {
-			req.ShortUrl = short.String()
 		}
-		req.RequestStatus = reqDone
-		req.ServiceReply = wrapReply(reply)
-		m.notifySuccess()
-	case "wikipedia":
-		reply, uri, err := m.queryWikipedia(wikipedia.EN, msg.Text)
-		if err != nil || len(reply) < 1 {
-			reply, uri, err = m.queryWikipedia(wikipedia.RU, msg.Text)
-		}
-		if err != nil {
-			req.RequestStatus = reqError
-			log.Printf("error querying %x: %s", msg.UUID, err.Error())
-			m.notifyError()
-			return nil
+	}
+	switch name {
+	case helpRoute:
+		req.ServiceReply = m.router.HelpText()
+	case remindRoute:
+		req.ServiceReply, err = m.reminders.Handle(msg.Address, query)
+	case subRoute, unsubRoute, stopRoute:
+		req.ServiceReply, err = m.subs.Handle(name, msg.Address, query)
+	case translitRoute:
+		if req.ServiceReply, err = m.translits.Handle(msg.Address, query); err == nil {
+			// the confirmation follows the new preference
+			req.Translit = m.translits.For(msg.Address)
 		}
-		if short, err := m.googlApi.Short(genericURL(uri)); err != nil {
-			log.Printf("error shorting url for %x: %s", msg.UUID, err.Error())
-			m.notifyError()
+	case moreRoute:
+		req.ServiceReply, req.Segments, err = m.pager.More(msg.Address, req.Translit)
+	default:
+		if len(query) < 1 {
+			// a bare keyword, the sender is told how to use it
+			req.ServiceReply = m.router.Usage(name)
 		} else {
-			req.ShortUrl = short.String()
+			err = m.answer(&req, svc, query)
 		}
-		req.RequestStatus = reqDone
-		req.ServiceReply = wrapReply(reply)
-		m.notifySuccess()
 	}
-	if err = m.sendReply(&req); err != nil {
+	if err != nil {
+		req.RequestStatus = reqError
+		log.Printf("error querying %x: %s", msg.UUID, err.Error())
+		m.notifyError()
+		if err = m.sendError(&req, classify(name, err)); err != nil {
+			log.Println("error sending error reply:", err)
+		}
+		return nil
+	}
+	req.RequestStatus = reqDone
+	m.notifySuccess()
+	if len(req.ServiceReply) < 1 {
+		err = m.sendError(&req, errNoAnswer)
+	} else if err = m.sendReply(&req); err != nil {
 		log.Println("error sending reply:", err)
 		m.notifyError()
+		err = m.sendError(&req, errInternal)
+	}
+	if err != nil {
+		log.Println("error sending error reply:", err)
 		return nil
 	}
 	if r, err := m.getReserve(); err != nil {
@@ -293,11 +609,116 @@ func (m *MessageHandler) HandleMessage(nmsg *nsq.Message) (err error) {
 	return
 }
 
+// throttle checks the request against the sender limits,
+// throttled senders are replied according to the error policy.
+func (m *MessageHandler) throttle(req *Request) bool {
+	reason, err := m.limits.Check(req)
+	if err != nil {
+		log.Printf("error checking limits of %s: %s", req.Address, err.Error())
+		m.notifyError()
+		return false
+	}
+	if len(reason) < 1 {
+		return false
+	}
+	req.RequestStatus = reqThrottled
+	log.Printf("request %d from %s throttled: %s", req.Id, req.Address, reason)
+	if reason == limitDenied {
+		return true
+	}
+	if err = m.sendError(req, errThrottled); err != nil {
+		log.Println("error sending throttled reply:", err)
+		m.notifyError()
+	}
+	return true
+}
+
+// route picks a service for the message, a number sent in reply
+// to the options offered earlier selects the option, it's chosen
+// and the choice is to be dropped once the request passes the limits.
+func (m *MessageHandler) route(msg *misc.Message) (name, query string, chosen bool) {
+	if n, ok := parseChoice(msg.Text); ok {
+		svc, option, found, err := m.choices.Peek(msg.Address, n)
+		if err != nil {
+			log.Printf("error fetching choice for %x: %s", msg.UUID, err.Error())
+			m.notifyError()
+		} else if found {
+			return svc, option, true
+		}
+	}
+	name, query = m.router.Route(msg.Origin, msg.Text)
+	return name, query, false
+}
+
+// answer queries the service and prepares the first page of the reply,
+// ambiguous results are offered to the sender as numbered options.
+func (m *MessageHandler) answer(req *Request, svc service.Service, query string) error {
+	err := m.queryService(req, svc, query)
+	if amb, ok := err.(*service.Ambiguous); ok {
+		req.ServiceReply, err = m.choices.Offer(req.Address, req.Service, amb.Options)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	var next int
+	req.Page, next = pageReply(req.ServiceReply, 0, req.ShortUrl, m.maxSegments(req.Service), req.Translit)
+	if err = m.pager.Reset(req.Address, req.Id, next); err != nil {
+		log.Printf("error saving cursor for request %d: %s", req.Id, err.Error())
+		m.notifyError()
+	}
+	return nil
+}
+
+// queryService fills the request with the service reply and a short link,
+// cached replies are used when fresh.
+func (m *MessageHandler) queryService(req *Request, svc service.Service, query string) error {
+	cached, ok, err := m.cache.Get(req.Service, query)
+	if err != nil {
+		log.Printf("error reading cache for request %d: %s", req.Id, err.Error())
+		m.notifyError()
+	} else if ok {
+		req.ServiceReply = cached.Reply
+		req.ShortUrl = cached.ShortUrl
+		req.CacheHit = true
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	reply, link, err := svc.Query(ctx, query)
+	sug, guess := err.(*service.Suggestion)
+	if guess {
+		reply, err = sug.Text, nil
+	}
+	if err != nil {
+		return err
+	}
+	if link != nil {
+		if short, err := m.googlApi.Short(link); err != nil {
+			log.Printf("error shorting url for request %d: %s", req.Id, err.Error())
+			m.notifyError()
+		} else {
+			req.ShortUrl = short.String()
+		}
+	}
+	req.ServiceReply = m.sanitizer.Apply(req.Service, reply)
+	if guess {
+		// not an answer, the query may well succeed next time
+		return nil
+	}
+	if err = m.cache.Put(req.Service, query, req.ServiceReply, req.ShortUrl); err != nil {
+		log.Printf("error caching reply for request %d: %s", req.Id, err.Error())
+		m.notifyError()
+	}
+	return nil
+}
+
 func (m *MessageHandler) getReserve() (r int, err error) {
 	var balance float32
-	if balance, err = m.smsApi.MyBalance(); err != nil {
+	if balance, err = m.sender.Balance(); err != nil {
 		return
 	}
+	m.budget.SetBalance(balance)
 	return int(balance / approxSmsCost), nil
 }
 
@@ -352,120 +773,184 @@ func (m *MessageHandler) notifyReserve(reserve int) (err error) {
 	return
 }
 
-func (m *MessageHandler) queryWolfram(input string) (reply string, err error) {
-	var res *wolfram.QueryResult
-	if res, err = m.wolfApi.Query(input); err != nil {
-		if err == wolfram.ErrUnknown {
-			return "", nil // nothing computed
+// sendReply sends the reply page through the outbox, if the provider counts
+// more segments than the service allows the reply falls back to a single segment.
+// Replies that would get over a budget limit are degraded.
+func (m *MessageHandler) sendReply(req *Request) error {
+	segments := req.Segments
+	if segments < 1 {
+		segments = m.maxSegments(req.Service)
+	}
+	text := replyText(req, req.ShortUrl, segments)
+	if segments > 1 {
+		_, n, err := m.smsCost(req.Address, text)
+		if err != nil {
+			return err
+		}
+		if n > segments {
+			log.Printf("reply to request %d takes %d messages of %d, sending single", req.Id, n, segments)
+			text = m.singleSegment(req, text, req.ShortUrl)
 		}
-		return "", err
 	}
-	if len(res.Pods) < 2 || len(res.Pods[1].SubPods) < 1 {
-		return
+	text, over, err := m.budgetReply(req, text)
+	if err != nil {
+		return err
 	}
-	reply = res.Pods[1].SubPods[0].Plaintext
-	return
+	id, ok, err := m.outbox.Send(req, text)
+	if err != nil {
+		return err
+	}
+	if ok {
+		m.deliveries.Sent(req, id)
+	}
+	if over {
+		// the reply is deferred by the outbox, the sender may be told so
+		return m.sendError(req, errOverBudget)
+	}
+	return nil
 }
 
-func (m *MessageHandler) queryWikipedia(lang wikipedia.Language, input string) (reply, uri string, err error) {
-	var res *wikipedia.SearchSuggestion
-	if res, err = m.wikiApi.Query(lang, input); err != nil {
-		err = errors.New("unable to query wikipedia: " + err.Error())
-		return
-	}
-	if len(res.Items) < 1 {
-		return
+// replyText returns the reply page with the link if it fits into the segments,
+// the page is transliterated if the sender prefers so.
+func replyText(req *Request, link string, segments int) string {
+	text := req.ServiceReply
+	if len(req.Page) > 0 {
+		text = req.Page
+	}
+	text = transliterate(text, req.Translit)
+	if len(link) > 0 {
+		if withUrl := text + " " + link; smsenc.Segments(withUrl) <= segments {
+			text = withUrl
+		}
 	}
-	reply = res.Items[0].Description
-	uri = res.Items[0].URL
-	return
+	return text
 }
 
-func wolframURL(input string) *url.URL {
-	v := url.Values{}
-	v.Set("i", input)
-	u, _ := url.ParseRequestURI(wolframUserURI)
-	u.RawQuery = v.Encode()
-	return u
+// sendOutbox passes the reply to the modem that received the request
+// if modem replies are enabled, errRelayed is returned then. The configured
+// backend is the fallback, it's used once the modem has been tried and
+// only its sends are checked against the budget, unless unchecked.
+func (m *MessageHandler) sendOutbox(msg *OutboxMessage) (id string, err error) {
+	if m.relays(msg.Origin) && len(msg.RelayId) < 1 {
+		err = m.relay.Send(msg.Origin, msg.Address, msg.Text, func(id string) error {
+			return m.outbox.Relaying(msg, id)
+		})
+		if err == nil {
+			return "", errRelayed
+		}
+		log.Printf("relay: unable to pass message %d to %s: %s", msg.Id, msg.Origin, err.Error())
+	}
+	if !msg.Unchecked {
+		if err = m.checkBudget(msg.Service, msg.Address, msg.Text); err != nil {
+			return
+		}
+	}
+	return m.sendMessage(msg.Service, msg.Address, msg.Text)
 }
 
-func genericURL(uri string) (u *url.URL) {
-	u, _ = url.ParseRequestURI(uri)
-	return
+// relays reports whether replies to requests from the origin modem
+// are relayed to it.
+func (m *MessageHandler) relays(origin string) bool {
+	return m.relay != nil && len(origin) > 0
 }
 
-func (m *MessageHandler) sendError(req *Request) error {
-	t := req.OpTimestamp.Format(`2 Jan 15:04`)
-	text := fmt.Sprintf("Внутренняя ошибка обработки запроса от %s", t)
-	sms := smsru.Sms{
-		To:   req.Address,
-		Text: text,
-		Test: true,
+// singleSegment returns the single SMS form of the reply text,
+// the pager cursor of a paged reply is moved to continue after it.
+func (m *MessageHandler) singleSegment(req *Request, text, link string) string {
+	if len(req.Page) < 1 {
+		page, _ := summary.Cut(text, 1, "")
+		return page
 	}
-	_, err := m.smsApi.SmsSend(&sms)
-	return err
+	page, next := pageReply(req.ServiceReply, 0, link, 1, req.Translit)
+	if err := m.pager.Reset(req.Address, req.Id, next); err != nil {
+		log.Printf("error saving cursor for request %d: %s", req.Id, err.Error())
+		m.notifyError()
+	}
+	req.Page = page
+	page = transliterate(page, req.Translit)
+	if len(link) > 0 {
+		page = page + " " + link
+	}
+	return page
 }
 
-func (m *MessageHandler) sendReply(req *Request) error {
-	var text string
-	if len(req.ServiceReply) < 1 {
-		t := req.OpTimestamp.Format(`2 Jan 15:04`)
-		text = fmt.Sprintf("На ваш запрос от %s не удалось получить ответ %s", t, req.ShortUrl)
-	} else {
-		text = req.ServiceReply
-		if isLatin(text) {
-			text = text + " " + req.ShortUrl
-		}
-	}
-	sms := smsru.Sms{
-		To:   req.Address,
-		Text: text,
+// maxSegments returns how many SMS a reply page of the service may take.
+func (m *MessageHandler) maxSegments(svc string) int {
+	if n := m.segments[svc]; n > 0 {
+		return n
 	}
-	cost, n, err := m.smsApi.SmsCost(&sms)
-	if err != nil {
-		return err
+	return defaultSegments
+}
+
+// sendNotice sends a message the recipient didn't request right now,
+// like reminders and digests, it refuses addresses that have opted out.
+// Notices are spent from the notices allocation and deferred by hard limits.
+// The text is sent as is, it's transliterated by the caller.
+func (m *MessageHandler) sendNotice(to, text string) error {
+	if m.subs.OptedOut(to) {
+		return errOptedOut
 	}
-	log.Println("reply to", req.Address, "is:", text)
-	log.Println("sent", n, "messages, total cost", cost)
-	if _, err = m.smsApi.SmsSend(&sms); err != nil {
+	if err := m.checkBudget(noticeService, to, text); err != nil {
 		return err
 	}
-	return nil
+	_, err := m.sendMessage(noticeService, to, text)
+	return err
 }
 
-// wrapReply is a very dumb sanitizer.
-func wrapReply(reply string) string {
-	if len(reply) < 1 {
-		return reply
+// sendReminder sends the reminder as a notice, transliterated
+// if the recipient prefers so.
+func (m *MessageHandler) sendReminder(to, text string) error {
+	return m.sendNotice(to, m.translits.Apply(to, text))
+}
+
+// smsCost estimates the text as it would be sent by sendMessage.
+func (m *MessageHandler) smsCost(to, text string) (cost float32, n int, err error) {
+	return m.sender.Cost(to, text)
+}
+
+// sendMessage sends the text via the configured backend and returns
+// the provider message ID, the cost is recorded as spent by the service.
+// Texts are transliterated before they get here.
+func (m *MessageHandler) sendMessage(svc, to, text string) (id string, err error) {
+	cost, n, err := m.sender.Cost(to, text)
+	if err != nil {
+		return "", err
 	}
-	r := strings.NewReplacer(
-		"  ", " ", ". ", ".", ", ", ",", "; ", ";",
-		"  ", " ", " .", ".", " ,", ",", " ;", ";",
-		" () ", " ", "()", "", " - ", " ", " – ", " ", " — ", " ",
-		" ( ) ", " ", " ( or  ) ", " ", "; ; ", "", "(, ", "(", " | ", "|",
-	)
-	reply = r.Replace(reply)
-	reply = cutStr(reply, latinSize-urlLen)
-	if !isLatin(reply) {
-		reply = cutStr(reply, cyrSize)
+	log.Println("reply to", to, "is:", text)
+	log.Println("sent", n, "messages, total cost", cost)
+	if id, err = m.sender.Send(to, text); err != nil {
+		return
+	}
+	if err := m.budget.Record(svc, cost, n); err != nil {
+		log.Println("budget: unable to record spend:", err)
 	}
-	reply = strings.TrimSpace(reply)
-	return reply
+	return id, nil
 }
 
-func cutStr(str string, n int) string {
-	runes := []rune(str)
-	if n < len(str) {
-		return string(runes[0:n])
-	}
-	return str
+// wrapReply sanitizes the reply with the default chain and cuts it
+// to fit a single page.
+func (m *MessageHandler) wrapReply(reply string) string {
+	page, _ := pageReply(m.sanitizer.Apply(sanitize.DefaultChain, reply), 0, "", defaultSegments, nil)
+	return page
 }
 
-func isLatin(text string) bool {
-	for _, r := range text {
-		if !unicode.In(r, unicode.Latin) {
-			return false
-		}
-	}
-	return true
+// pageReply returns a page of the reply that fits into the segments along
+// with the link, if any, starting at the rune offset provided, next is the
+// offset of the following page. Pages are cut at sentence or word boundaries.
+// The page is cut from the reply as is, so offsets don't depend on the table,
+// and fits once transliterated with it, if any.
+func pageReply(reply string, offset int, link string, segments int, table *translit.Table) (page string, next int) {
+	runes := []rune(reply)
+	if offset >= len(runes) {
+		return "", len(runes)
+	}
+	var suffix string
+	if len(link) > 0 {
+		suffix = " " + link
+	}
+	convert := func(text string) string {
+		return transliterate(text, table)
+	}
+	page, next = summary.CutConverted(string(runes[offset:]), segments, suffix, convert)
+	return page, offset + next
 }
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/jinzhu/gorm"
+)
+
+const deadLetterTopic = "dead_letters"
+
+const (
+	outboxInterval    = 10 * time.Second
+	maxOutboxAttempts = 6
+	outboxBaseDelay   = 30 * time.Second
+	outboxMaxDelay    = time.Hour
+	// outboxLease keeps the ticker off a message while its first attempt
+	// is in flight, a message is retried if the attempt never saved.
+	outboxLease = time.Minute
+)
+
+const (
+	outboxPending int8 = iota
+	outboxSent
+	outboxDead
+	outboxRelayed // waits for the modem report
+)
+
+// OutboxMessage is a reply stored before it's sent, so failed sends
+// are retried and survive restarts.
+type OutboxMessage struct {
+	Id        int64
+	RequestId int64
+	Service   string `sql:"size:20"`
+	Origin    string `sql:"size:20"`
+	Address   string `sql:"size:20"`
+	Text      string
+	// RelayId is set once the message has been passed to a modem,
+	// later attempts use the backend.
+	RelayId string `sql:"size:32"`
+	// Unchecked messages aren't checked against the budget,
+	// like the notice that a reply is deferred by it.
+	Unchecked bool
+	// Notice is set for error notices, the delivery tracked
+	// on the request is the one of its reply.
+	Notice       bool
+	OutboxStatus int8
+	Attempts     int
+	LastError    string
+	NextAt       time.Time
+	CreatedAt    time.Time
+	SentAt       time.Time
+}
+
+// Outbox sends stored replies and retries the failed ones with exponential
+// backoff and jitter, after maxOutboxAttempts they're dead letters.
+// Dead letters are published to deadLetterTopic and kept to be requeued.
+type Outbox struct {
+	db       *gorm.DB
+	producer publisher
+	send     func(msg *OutboxMessage) (id string, err error)
+	// sent is called when a retry succeeds, dead when the message is given up.
+	sent func(msg *OutboxMessage, id string)
+	dead func(msg *OutboxMessage)
+	quit chan struct{}
+}
+
+func NewOutbox(db *gorm.DB, producer publisher, send func(msg *OutboxMessage) (string, error),
+	sent func(msg *OutboxMessage, id string), dead func(msg *OutboxMessage)) (o *Outbox, err error) {
+	if err = db.AutoMigrate(OutboxMessage{}).Error; err != nil {
+		return nil, err
+	}
+	o = &Outbox{
+		db:       db,
+		producer: producer,
+		send:     send,
+		sent:     sent,
+		dead:     dead,
+		quit:     make(chan struct{}),
+	}
+	return
+}
+
+// Send stores the reply and makes the first attempt, ok is set
+// if it has been sent, otherwise it's left for retries.
+func (o *Outbox) Send(req *Request, text string) (id string, ok bool, err error) {
+	return o.store(newOutboxMessage(req, text))
+}
+
+// SendNotice is Send of an error notice, unchecked ones aren't checked
+// against the budget.
+func (o *Outbox) SendNotice(req *Request, text string, unchecked bool) (id string, ok bool, err error) {
+	msg := newOutboxMessage(req, text)
+	msg.Notice = true
+	msg.Unchecked = unchecked
+	return o.store(msg)
+}
+
+func newOutboxMessage(req *Request, text string) *OutboxMessage {
+	now := time.Now()
+	return &OutboxMessage{
+		RequestId:    req.Id,
+		Service:      req.Service,
+		Origin:       req.Origin,
+		Address:      req.Address,
+		Text:         text,
+		OutboxStatus: outboxPending,
+		NextAt:       now.Add(outboxLease),
+		CreatedAt:    now,
+	}
+}
+
+func (o *Outbox) store(msg *OutboxMessage) (id string, ok bool, err error) {
+	if err = o.db.Create(msg).Error; err != nil {
+		return
+	}
+	id, ok = o.attempt(msg)
+	return id, ok, nil
+}
+
+// attempt tries to send the message and saves the outcome,
+// messages deferred by the budget don't spend attempts.
+func (o *Outbox) attempt(msg *OutboxMessage) (id string, ok bool) {
+	var err error
+	msg.Attempts++
+	if id, err = o.send(msg); err != nil {
+		if err == errRelayed {
+			// saved by Relaying, the modem may have reported already
+			return
+		} else if d, deferred := err.(*deferredError); deferred {
+			log.Printf("outbox: message %d: %s", msg.Id, err.Error())
+			msg.Attempts--
+			msg.NextAt = d.limit.Until
+		} else {
+			o.fail(msg, err)
+		}
+	} else {
+		ok = true
+		msg.OutboxStatus = outboxSent
+		msg.SentAt = time.Now()
+	}
+	if err = o.db.Save(msg).Error; err != nil {
+		log.Printf("outbox: unable to save message %d: %s", msg.Id, err.Error())
+	}
+	return
+}
+
+// Relaying stores the message as relayed with the ID, it's called before
+// the message is published so the modem report always finds it.
+func (o *Outbox) Relaying(msg *OutboxMessage, relayId string) error {
+	msg.RelayId = relayId
+	return o.db.Model(&OutboxMessage{}).Where("id = ?", msg.Id).
+		Updates(map[string]interface{}{
+			"relay_id":      relayId,
+			"outbox_status": outboxRelayed,
+			"attempts":      msg.Attempts,
+			"next_at":       time.Now().Add(relayTimeout),
+		}).Error
+}
+
+// fail schedules the next attempt, the message is dead after the last one.
+func (o *Outbox) fail(msg *OutboxMessage, err error) {
+	log.Printf("outbox: attempt %d of message %d failed: %s", msg.Attempts, msg.Id, err.Error())
+	msg.LastError = err.Error()
+	msg.NextAt = time.Now().Add(backoff(msg.Attempts))
+	if msg.Attempts < maxOutboxAttempts {
+		return
+	}
+	msg.OutboxStatus = outboxDead
+	if err = o.publishDeadLetter(msg); err != nil {
+		log.Printf("outbox: unable to publish dead letter %d: %s", msg.Id, err.Error())
+	}
+	o.dead(msg)
+}
+
+func (o *Outbox) publishDeadLetter(msg *OutboxMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return o.producer.Publish(deadLetterTopic, body)
+}
+
+// backoff doubles the delay with every attempt, half of it is random.
+func backoff(attempts int) time.Duration {
+	d := outboxMaxDelay
+	if attempts < 20 {
+		if d = outboxBaseDelay << uint(attempts-1); d > outboxMaxDelay {
+			d = outboxMaxDelay
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// Requeue makes dead letters pending again, all of them if no IDs are given.
+func (o *Outbox) Requeue(ids ...int64) (n int64, err error) {
+	db := o.db.Model(&OutboxMessage{}).Where("outbox_status = ?", outboxDead)
+	if len(ids) > 0 {
+		db = db.Where("id IN (?)", ids)
+	}
+	db = db.Updates(map[string]interface{}{
+		"outbox_status": outboxPending,
+		"attempts":      0,
+		"next_at":       time.Now(),
+	})
+	return db.RowsAffected, db.Error
+}
+
+// Run retries due messages until Stop is called.
+func (o *Outbox) Run() {
+	t := time.NewTicker(outboxInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-o.quit:
+			return
+		case <-t.C:
+			o.retry()
+		}
+	}
+}
+
+func (o *Outbox) Stop() {
+	close(o.quit)
+}
+
+func (o *Outbox) retry() {
+	var msgs []*OutboxMessage
+	if err := o.db.Where("outbox_status IN (?) AND next_at <= ?",
+		[]int8{outboxPending, outboxRelayed}, time.Now()).
+		Order("next_at").Find(&msgs).Error; err != nil {
+		log.Println("outbox: unable to fetch messages:", err)
+		return
+	}
+	for _, msg := range msgs {
+		if msg.OutboxStatus == outboxRelayed {
+			// the modem didn't report in time
+			o.relayFailed(msg, "no report in time")
+			continue
+		}
+		if id, ok := o.attempt(msg); ok {
+			o.sent(msg, id)
+		}
+	}
+}
+
+// Relayed marks the relayed message as sent by the modem with the message ID.
+func (o *Outbox) Relayed(relayId, messageId string) {
+	msg, ok := o.relayed(relayId)
+	if !ok {
+		return
+	}
+	db := o.db.Model(&OutboxMessage{}).Where("id = ? AND outbox_status = ?", msg.Id, outboxRelayed).
+		Updates(map[string]interface{}{
+			"outbox_status": outboxSent,
+			"sent_at":       time.Now(),
+		})
+	if db.Error != nil {
+		log.Printf("outbox: unable to save message %d: %s", msg.Id, db.Error.Error())
+		return
+	}
+	if db.RowsAffected > 0 {
+		log.Printf("outbox: message %d sent by %s", msg.Id, msg.Origin)
+		o.sent(msg, messageId)
+	}
+}
+
+// RelayFailed sends the relayed message with the backend.
+func (o *Outbox) RelayFailed(relayId, reason string) {
+	if msg, ok := o.relayed(relayId); ok {
+		o.relayFailed(msg, reason)
+	}
+}
+
+func (o *Outbox) relayed(relayId string) (msg *OutboxMessage, ok bool) {
+	msg = &OutboxMessage{}
+	if err := o.db.Where("relay_id = ? AND outbox_status = ?", relayId, outboxRelayed).
+		First(msg).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			log.Printf("outbox: unable to fetch relayed %s: %s", relayId, err.Error())
+		}
+		return nil, false
+	}
+	return msg, true
+}
+
+// relayFailed takes the message back from the modem and makes an attempt,
+// unless it's been reported or taken back already.
+func (o *Outbox) relayFailed(msg *OutboxMessage, reason string) {
+	db := o.db.Model(&OutboxMessage{}).Where("id = ? AND outbox_status = ?", msg.Id, outboxRelayed).
+		Update("outbox_status", outboxPending)
+	if db.Error != nil {
+		log.Printf("outbox: unable to save message %d: %s", msg.Id, db.Error.Error())
+		return
+	}
+	if db.RowsAffected < 1 {
+		return
+	}
+	log.Printf("outbox: relay of message %d failed (%s), using the backend", msg.Id, reason)
+	msg.OutboxStatus = outboxPending
+	msg.LastError = reason
+	if id, ok := o.attempt(msg); ok {
+		o.sent(msg, id)
+	}
+}
+
+// replySent records the delivery tracking of a reply sent on retry.
+func (m *MessageHandler) replySent(msg *OutboxMessage, id string) {
+	if msg.Notice {
+		return
+	}
+	var req Request
+	if err := m.db.First(&req, msg.RequestId).Error; err != nil {
+		log.Printf("outbox: unable to fetch request %d: %s", msg.RequestId, err.Error())
+		return
+	}
+	m.deliveries.Sent(&req, id)
+	if err := m.db.Save(&req).Error; err != nil {
+		log.Printf("outbox: unable to save request %d: %s", req.Id, err.Error())
+	}
+}
+
+// deadLetter notifies the operator of the message given up on.
+func (m *MessageHandler) deadLetter(msg *OutboxMessage) {
+	log.Printf("outbox: message %d to %s is a dead letter: %s", msg.Id, msg.Address, msg.LastError)
+	m.notifyError()
+}
+
+// requeueDeadLetters is the requeue command action.
+func requeueDeadLetters(c *cli.Context) {
+	ids := make([]int64, 0, len(c.Args()))
+	for _, arg := range c.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			log.Fatalln("bad message id:", arg)
+		}
+		ids = append(ids, id)
+	}
+	dbCfg := &dbConfig{}
+	if err := dbCfg.ReadFromFile(c.GlobalString("db-cfg")); err != nil {
+		log.Fatalln(err)
+	}
+	db, err := gorm.Open("postgres", dbCfg.DataSourceName())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	outbox, err := NewOutbox(&db, nil, nil, nil, nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	n, err := outbox.Requeue(ids...)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("requeued", n, "dead letters")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testOutbox records what the outbox does with the messages.
+type testOutbox struct {
+	*Outbox
+	pub   *testPublisher
+	sends []*OutboxMessage
+	sent  map[int64]string
+	dead  []int64
+}
+
+// newTestOutbox creates an outbox that sends with the function.
+func newTestOutbox(t *testing.T, send func(o *testOutbox, msg *OutboxMessage) (string, error)) *testOutbox {
+	db := openTestDB(t, OutboxMessage{})
+	o := &testOutbox{pub: &testPublisher{}, sent: make(map[int64]string)}
+	var err error
+	o.Outbox, err = NewOutbox(db, o.pub, func(msg *OutboxMessage) (string, error) {
+		o.sends = append(o.sends, msg)
+		return send(o, msg)
+	}, func(msg *OutboxMessage, id string) {
+		o.sent[msg.Id] = id
+	}, func(msg *OutboxMessage) {
+		o.dead = append(o.dead, msg.Id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func (o *testOutbox) load(t *testing.T, id int64) *OutboxMessage {
+	var msg OutboxMessage
+	if err := o.db.First(&msg, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+// relaySend relays the first attempt, later ones are sent by the backend.
+func relaySend(o *testOutbox, msg *OutboxMessage) (string, error) {
+	if len(msg.RelayId) < 1 {
+		if err := o.Relaying(msg, "relay"+msg.Address); err != nil {
+			return "", err
+		}
+		return "", errRelayed
+	}
+	return "backend" + msg.Address, nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	o := newTestOutbox(t, relaySend)
+	req := &Request{Id: 1, Service: "wolfram", Origin: "modem1", Address: "1"}
+	_, ok, err := o.Send(req, "reported")
+	assert.NoError(t, err)
+	assert.False(t, ok, "sent when the modem reports")
+	req.Address = "2"
+	_, _, err = o.Send(req, "failed")
+	assert.NoError(t, err)
+	req.Address = "3"
+	_, _, err = o.Send(req, "timed out")
+	assert.NoError(t, err)
+	if !assert.Len(t, o.sends, 3) {
+		return
+	}
+	reported, failed, silent := o.sends[0], o.sends[1], o.sends[2]
+	assert.Equal(t, outboxRelayed, o.load(t, reported.Id).OutboxStatus)
+
+	// the ticker leaves them to the modem
+	o.retry()
+	assert.Len(t, o.sends, 3)
+
+	o.Relayed("relay1", "modem1:42")
+	o.Relayed("relay1", "modem1:43")
+	assert.Equal(t, outboxSent, o.load(t, reported.Id).OutboxStatus)
+	assert.Equal(t, map[int64]string{reported.Id: "modem1:42"}, o.sent)
+
+	o.RelayFailed("relay2", "modem1: no network")
+	o.RelayFailed("relay2", "modem1: no network")
+	msg := o.load(t, failed.Id)
+	assert.Equal(t, outboxSent, msg.OutboxStatus)
+	assert.Equal(t, "modem1: no network", msg.LastError)
+	assert.Equal(t, "backend2", o.sent[failed.Id])
+	assert.Len(t, o.sends, 4)
+
+	// reported too late, the backend has been used
+	assert.NoError(t, o.db.Model(&OutboxMessage{}).Where("id = ?", silent.Id).
+		Update("next_at", time.Now().Add(-time.Second)).Error)
+	o.retry()
+	assert.Equal(t, "backend3", o.sent[silent.Id])
+	o.Relayed("relay3", "modem1:44")
+	assert.Equal(t, "backend3", o.sent[silent.Id])
+	assert.Len(t, o.sends, 5)
+	assert.Empty(t, o.dead)
+}
+
+func TestOutboxFastReport(t *testing.T) {
+	o := newTestOutbox(t, func(o *testOutbox, msg *OutboxMessage) (string, error) {
+		if err := o.Relaying(msg, "relay"); err != nil {
+			return "", err
+		}
+		// the modem reports before the attempt returns
+		o.Relayed("relay", "modem1:42")
+		return "", errRelayed
+	})
+	_, ok, err := o.Send(&Request{Id: 1, Origin: "modem1", Address: "1"}, "text")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	msg := o.load(t, o.sends[0].Id)
+	assert.Equal(t, outboxSent, msg.OutboxStatus)
+	assert.Equal(t, "relay", msg.RelayId)
+	assert.Equal(t, 1, msg.Attempts)
+	assert.Equal(t, map[int64]string{msg.Id: "modem1:42"}, o.sent)
+}
+
+func TestOutboxRelayDeferred(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	o := newTestOutbox(t, func(o *testOutbox, msg *OutboxMessage) (string, error) {
+		if len(msg.RelayId) < 1 {
+			if err := o.Relaying(msg, "relay"); err != nil {
+				return "", err
+			}
+			return "", errRelayed
+		}
+		return "", &deferredError{limit: &Limit{Name: "daily", Until: until}}
+	})
+	_, _, err := o.Send(&Request{Id: 1, Origin: "modem1", Address: "1"}, "text")
+	assert.NoError(t, err)
+	o.RelayFailed("relay", "modem1: no network")
+	msg := o.load(t, o.sends[0].Id)
+	assert.Equal(t, outboxPending, msg.OutboxStatus)
+	assert.Equal(t, 1, msg.Attempts)
+	assert.WithinDuration(t, until, msg.NextAt, time.Second)
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, outboxBaseDelay},
+		{2, 2 * outboxBaseDelay},
+		{3, 4 * outboxBaseDelay},
+		{7, 64 * outboxBaseDelay},
+		{8, outboxMaxDelay},
+		{64, outboxMaxDelay},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := backoff(tt.attempts)
+			assert.True(t, d >= tt.max/2 && d < tt.max, "attempt %d: %s", tt.attempts, d)
+		}
+	}
+}
+
+func TestOutboxInFlight(t *testing.T) {
+	o := newTestOutbox(t, func(o *testOutbox, msg *OutboxMessage) (string, error) {
+		if len(o.sends) == 1 {
+			// the ticker fires while the first attempt is in flight
+			o.retry()
+		}
+		return "42", nil
+	})
+	id, ok, err := o.Send(&Request{Id: 1, Address: "1"}, "text")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "42", id)
+	assert.Len(t, o.sends, 1)
+	assert.Empty(t, o.sent, "not a retry")
+	o.retry()
+	assert.Len(t, o.sends, 1)
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	o := newTestOutbox(t, func(o *testOutbox, msg *OutboxMessage) (string, error) {
+		return "", errors.New("gateway is down")
+	})
+	_, ok, err := o.Send(&Request{Id: 1, Address: "1"}, "text")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	id := o.sends[0].Id
+	due := func() {
+		assert.NoError(t, o.db.Model(&OutboxMessage{}).Where("id = ?", id).
+			Update("next_at", time.Now().Add(-time.Second)).Error)
+	}
+	for i := 1; i < maxOutboxAttempts; i++ {
+		msg := o.load(t, id)
+		assert.Equal(t, outboxPending, msg.OutboxStatus)
+		assert.Equal(t, i, msg.Attempts)
+		assert.Equal(t, "gateway is down", msg.LastError)
+		assert.True(t, msg.NextAt.After(time.Now()), "backed off")
+		due()
+		o.retry()
+	}
+	assert.Equal(t, outboxDead, o.load(t, id).OutboxStatus)
+	assert.Equal(t, []int64{id}, o.dead)
+	assert.Equal(t, deadLetterTopic, o.pub.topic)
+	var dead OutboxMessage
+	if assert.NoError(t, json.Unmarshal(o.pub.body, &dead)) {
+		assert.Equal(t, id, dead.Id)
+		assert.Equal(t, outboxDead, dead.OutboxStatus)
+		assert.Equal(t, "gateway is down", dead.LastError)
+	}
+	due()
+	o.retry()
+	assert.Len(t, o.sends, maxOutboxAttempts)
+
+	n, err := o.Requeue()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	msg := o.load(t, id)
+	assert.Equal(t, outboxPending, msg.OutboxStatus)
+	assert.Equal(t, 0, msg.Attempts)
+}
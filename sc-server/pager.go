@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xlab/smscloud/translit"
+)
+
+// moreRoute is the built-in route that sends the next page of the last reply.
+const moreRoute = "more"
+
+const noMoreReply = "Продолжения нет"
+
+// ReplyCursor points to the unsent part of the last reply to the address.
+type ReplyCursor struct {
+	Id        int64
+	Address   string `sql:"size:20"`
+	RequestId int64
+	Offset    int // in runes
+	UpdatedAt time.Time
+}
+
+// Pager keeps a cursor per sender, so cut off replies can be continued.
+// Offsets point into the stored reply, pages are transliterated when sent,
+// so a changed preference doesn't shift them.
+type Pager struct {
+	db       *gorm.DB
+	segments func(svc string) int
+}
+
+// NewPager creates a pager, segments returns the page size of the service.
+func NewPager(db *gorm.DB, segments func(svc string) int) (p *Pager, err error) {
+	if err = db.AutoMigrate(ReplyCursor{}).Error; err != nil {
+		return nil, err
+	}
+	return &Pager{db: db, segments: segments}, nil
+}
+
+// Reset points the cursor of the address to the request's reply at offset.
+func (p *Pager) Reset(addr string, reqId int64, offset int) (err error) {
+	var cur ReplyCursor
+	if err = p.db.Where("address = ?", addr).First(&cur).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			return
+		}
+		cur = ReplyCursor{Address: addr}
+	}
+	cur.RequestId = reqId
+	cur.Offset = offset
+	cur.UpdatedAt = time.Now()
+	return p.db.Save(&cur).Error
+}
+
+// More returns the next page of the last reply to the address and advances
+// the cursor, segments is the page size of the replying service. The page
+// fits once transliterated with the table, if any, it's returned as is.
+func (p *Pager) More(addr string, table *translit.Table) (page string, segments int, err error) {
+	segments = defaultSegments
+	var cur ReplyCursor
+	if err = p.db.Where("address = ?", addr).First(&cur).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return noMoreReply, segments, nil
+		}
+		return
+	}
+	var req Request
+	if err = p.db.First(&req, cur.RequestId).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return noMoreReply, segments, nil
+		}
+		return
+	}
+	segments = p.segments(req.Service)
+	var next int
+	if page, next = pageReply(req.ServiceReply, cur.Offset, "", segments, table); len(page) < 1 {
+		return noMoreReply, segments, nil
+	}
+	cur.Offset = next
+	cur.UpdatedAt = time.Now()
+	if err = p.db.Save(&cur).Error; err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/xlab/smscloud/misc"
+)
+
+const reportChannel = "sc_server"
+
+// relayTimeout is how long a modem has to report, the fallback is used then.
+const relayTimeout = 2 * time.Minute
+
+// errRelayed is returned by the outbox send when the text has been passed
+// to a modem, the message is sent once the modem reports so.
+var errRelayed = errors.New("relayed to the modem")
+
+type publisher interface {
+	Publish(topic string, body []byte) error
+}
+
+// Relay sends replies through the sc-client modem that received the request.
+// Relayed messages are kept by the outbox until the modem reports,
+// so they survive restarts and fall back to the backend on failures.
+type Relay struct {
+	producer publisher
+	// sent and failed are called with the relay ID on modem reports,
+	// sent is given the ID the delivery of the message is reported with.
+	sent   func(id, messageId string)
+	failed func(id, reason string)
+}
+
+func NewRelay(producer publisher, sent func(id, messageId string), failed func(id, reason string)) *Relay {
+	return &Relay{
+		producer: producer,
+		sent:     sent,
+		failed:   failed,
+	}
+}
+
+// Send publishes the text for the modem to send, save is called
+// with the relay ID the modem reports with before it's published.
+func (r *Relay) Send(modem, to, text string, save func(id string) error) error {
+	uuid, err := misc.GenUUID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	out := misc.Outgoing{
+		UUID:      uuid,
+		Origin:    modem,
+		Text:      text,
+		Address:   to,
+		Timestamp: now,
+		Deadline:  now.Add(relayTimeout),
+	}
+	body, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if err = save(hex.EncodeToString(uuid)); err != nil {
+		return err
+	}
+	return r.producer.Publish(misc.OutgoingTopic(modem), body)
+}
+
+// HandleMessage handles modem reports.
+func (r *Relay) HandleMessage(nmsg *nsq.Message) error {
+	var report misc.SendReport
+	if err := json.Unmarshal(nmsg.Body, &report); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(report.UUID)
+	if report.Sent {
+		r.sent(id, misc.ModemMessageId(report.Origin, report.Reference))
+		return nil
+	}
+	r.failed(id, report.Origin+": "+report.Error)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/bitly/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/misc"
+)
+
+type testPublisher struct {
+	topic string
+	body  []byte
+	err   error
+}
+
+func (p *testPublisher) Publish(topic string, body []byte) error {
+	p.topic, p.body = topic, body
+	return p.err
+}
+
+func TestRelaySend(t *testing.T) {
+	p := &testPublisher{}
+	r := NewRelay(p, nil, nil)
+	var id string
+	save := func(relayId string) error {
+		assert.Empty(t, p.topic, "saved before it's published")
+		id = relayId
+		return nil
+	}
+	if !assert.NoError(t, r.Send("modem1", "+79991234567", "hello", save)) {
+		return
+	}
+	assert.Equal(t, misc.OutgoingTopic("modem1"), p.topic)
+	var out misc.Outgoing
+	if assert.NoError(t, json.Unmarshal(p.body, &out)) {
+		assert.Equal(t, id, hex.EncodeToString(out.UUID))
+		assert.Equal(t, "modem1", out.Origin)
+		assert.Equal(t, "+79991234567", out.Address)
+		assert.Equal(t, "hello", out.Text)
+		assert.Equal(t, out.Timestamp.Add(relayTimeout), out.Deadline)
+	}
+
+	p.topic = ""
+	saveErr := errors.New("db is down")
+	err := r.Send("modem1", "+79991234567", "hello", func(string) error {
+		return saveErr
+	})
+	assert.Equal(t, saveErr, err)
+	assert.Empty(t, p.topic, "not published")
+
+	p.err = errors.New("nsqd is down")
+	assert.Equal(t, p.err, r.Send("modem1", "+79991234567", "hello", save))
+}
+
+func TestRelayReports(t *testing.T) {
+	var sent, messageId, failed, reason string
+	r := NewRelay(nil, func(id, msgId string) {
+		sent, messageId = id, msgId
+	}, func(id, why string) {
+		failed, reason = id, why
+	})
+	report := func(uuid []byte, ok bool, why string) *nsq.Message {
+		body, _ := json.Marshal(misc.SendReport{UUID: uuid, Origin: "modem1", Sent: ok, Error: why, Reference: 42})
+		return &nsq.Message{Body: body}
+	}
+	assert.NoError(t, r.HandleMessage(report([]byte{0xab, 0x01}, true, "")))
+	assert.Equal(t, "ab01", sent)
+	assert.Equal(t, "modem1:42", messageId)
+	assert.Empty(t, failed)
+
+	assert.NoError(t, r.HandleMessage(report([]byte{0xcd}, false, "no network")))
+	assert.Equal(t, "cd", failed)
+	assert.Equal(t, "modem1: no network", reason)
+
+	assert.Error(t, r.HandleMessage(&nsq.Message{Body: []byte("{")}))
+}
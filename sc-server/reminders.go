@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remindRoute is the built-in route that manages reminders.
+const remindRoute = "remind"
+
+const (
+	maxReminders     = 10
+	maxReminderDelay = 30 * 24 * time.Hour
+)
+
+// reminderPrefix is prepended to the text, it's counted into the job text size.
+const reminderPrefix = "Напоминание: "
+
+// maxReminderText is how many characters of the text fit into a job.
+var maxReminderText = 160 - len([]rune(reminderPrefix))
+
+const remindUsage = "Формат: remind 18:30 текст, remind 2h текст, remind list, remind cancel <номер>"
+
+var errBadDelay = errors.New("reminders: unable to parse time")
+
+var (
+	clockTime = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})$`)
+	delayPart = regexp.MustCompile(`(\d+)(мин|min|ч|h|м|m|д|d)`)
+)
+
+var delayUnits = map[string]time.Duration{
+	"m":   time.Minute,
+	"м":   time.Minute,
+	"min": time.Minute,
+	"мин": time.Minute,
+	"h":   time.Hour,
+	"ч":   time.Hour,
+	"d":   24 * time.Hour,
+	"д":   24 * time.Hour,
+}
+
+// Reminders implements the remind command on top of the scheduler.
+// Clock times are read in the loc timezone, not the sender's one.
+type Reminders struct {
+	sched *Scheduler
+	loc   *time.Location
+}
+
+// Handle processes the remind command text and returns a reply for the sender.
+func (r *Reminders) Handle(addr, text string) (reply string, err error) {
+	cmd, rest := splitKeyword(text)
+	switch strings.ToLower(cmd) {
+	case "list", "список":
+		return r.list(addr)
+	case "cancel", "отмена":
+		return r.cancel(addr, rest)
+	}
+	due, err := parseDue(cmd, time.Now().In(r.loc))
+	if err != nil || len(rest) < 1 {
+		return remindUsage, nil
+	}
+	if len([]rune(rest)) > maxReminderText {
+		return fmt.Sprintf("Текст напоминания длиннее %d символов", maxReminderText), nil
+	}
+	var jobs []*Job
+	if jobs, err = r.sched.Pending(addr); err != nil {
+		return
+	}
+	if len(jobs) >= maxReminders {
+		return fmt.Sprintf("Нельзя создать больше %d напоминаний", maxReminders), nil
+	}
+	var job *Job
+	if job, err = r.sched.Schedule(addr, reminderPrefix+rest, due); err != nil {
+		return
+	}
+	return fmt.Sprintf("Напоминание #%d на %s", job.Id, job.DueAt.Format(`2 Jan 15:04`)), nil
+}
+
+func (r *Reminders) list(addr string) (reply string, err error) {
+	var jobs []*Job
+	if jobs, err = r.sched.Pending(addr); err != nil {
+		return
+	}
+	if len(jobs) < 1 {
+		return "Нет активных напоминаний", nil
+	}
+	items := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		text := strings.TrimPrefix(job.Text, reminderPrefix)
+		items = append(items, fmt.Sprintf("#%d %s %s", job.Id, job.DueAt.In(r.loc).Format(`2 Jan 15:04`), text))
+	}
+	return strings.Join(items, "\n"), nil
+}
+
+func (r *Reminders) cancel(addr, arg string) (reply string, err error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		return remindUsage, nil
+	}
+	var ok bool
+	if ok, err = r.sched.Cancel(addr, id); err != nil {
+		return
+	}
+	if !ok {
+		return fmt.Sprintf("Напоминание #%d не найдено", id), nil
+	}
+	return fmt.Sprintf("Напоминание #%d отменено", id), nil
+}
+
+// parseDue reads either a clock time like 18:30 or a delay like 2h, 1h30m, 2д.
+func parseDue(s string, now time.Time) (due time.Time, err error) {
+	s = strings.ToLower(s)
+	if clockTime.MatchString(s) {
+		return nextClock(s, now)
+	}
+	parts := delayPart.FindAllStringSubmatch(s, -1)
+	if parts == nil || len(delayPart.ReplaceAllString(s, "")) > 0 {
+		return due, errBadDelay
+	}
+	var delay time.Duration
+	for _, p := range parts {
+		n, _ := strconv.Atoi(p[1])
+		delay += time.Duration(n) * delayUnits[p[2]]
+	}
+	if delay <= 0 || delay > maxReminderDelay {
+		return due, errBadDelay
+	}
+	return now.Add(delay), nil
+}
+
+// nextClock returns the nearest moment after now at the clock time like 18:30,
+// the clock time is in the timezone of now.
+func nextClock(s string, now time.Time) (next time.Time, err error) {
+	m := clockTime.FindStringSubmatch(s)
+	if m == nil {
+		return next, errBadDelay
+	}
+	hour, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	if hour > 23 || min > 59 {
+		return next, errBadDelay
+	}
+	next = time.Date(now.Year(), now.Month(), now.Day(), hour, min, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDue(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2015, 3, 10, 12, 0, 0, 0, msk)
+	tests := []struct {
+		in  string
+		due time.Time
+		err error
+	}{
+		{"18:30", time.Date(2015, 3, 10, 18, 30, 0, 0, msk), nil},
+		{"9.05", time.Date(2015, 3, 11, 9, 5, 0, 0, msk), nil},
+		{"12:00", time.Date(2015, 3, 11, 12, 0, 0, 0, msk), nil},
+		{"2h", now.Add(2 * time.Hour), nil},
+		{"1h30m", now.Add(90 * time.Minute), nil},
+		{"1Ч30МИН", now.Add(90 * time.Minute), nil},
+		{"2д", now.Add(48 * time.Hour), nil},
+		{"30d", now.Add(30 * 24 * time.Hour), nil},
+		{"31d", time.Time{}, errBadDelay},
+		{"0m", time.Time{}, errBadDelay},
+		{"2hours", time.Time{}, errBadDelay},
+		{"24:00", time.Time{}, errBadDelay},
+		{"12:60", time.Time{}, errBadDelay},
+		{"завтра", time.Time{}, errBadDelay},
+	}
+	for _, tt := range tests {
+		due, err := parseDue(tt.in, now)
+		assert.Equal(t, tt.err, err, tt.in)
+		assert.True(t, tt.due.Equal(due), "%s: %s", tt.in, due)
+	}
+}
+
+func TestNextClockLocation(t *testing.T) {
+	// 23:30 UTC is already the next day in Moscow
+	now := time.Date(2015, 3, 10, 23, 30, 0, 0, time.UTC)
+	msk := time.FixedZone("MSK", 3*60*60)
+	next, err := nextClock("09:00", now.In(msk))
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2015, 3, 11, 9, 0, 0, 0, msk).Equal(next), next.String())
+	next, err = nextClock("09:00", now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2015, 3, 11, 9, 0, 0, 0, time.UTC).Equal(next), next.String())
+}
+
+func TestReminderTextSize(t *testing.T) {
+	// rejected before the scheduler is asked
+	r := &Reminders{loc: time.UTC}
+	reply, err := r.Handle("+79991234567", "2h "+strings.Repeat("я", maxReminderText+1))
+	assert.NoError(t, err)
+	assert.Equal(t, "Текст напоминания длиннее 147 символов", reply)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/xlab/smscloud/service"
+	"github.com/xlab/smscloud/smsenc"
+)
+
+// helpRoute is the built-in route that replies with the keyword table.
+const helpRoute = "help"
+
+// builtinRoutes are handled by sc-server itself and may be used
+// as keyword targets along with the registered services.
+var builtinRoutes = map[string]bool{
+	helpRoute:     true,
+	remindRoute:   true,
+	subRoute:      true,
+	unsubRoute:    true,
+	stopRoute:     true,
+	moreRoute:     true,
+	translitRoute: true,
+}
+
+// defaultHelpHeader is in GSM 03.38, so the HELP reply fits a single SMS.
+const defaultHelpHeader = "Send <keyword> <query>:"
+
+type routesConfig struct {
+	// Keywords maps a text prefix to the registered service name or a built-in route.
+	Keywords map[string]string `json:"keywords"`
+	// Patterns route the whole text to a service if it matches a regexp.
+	Patterns []routePattern `json:"patterns"`
+	// Help lists prefixes that trigger the HELP reply.
+	Help []string `json:"help"`
+	// HelpHeader is prepended to the keyword list in the HELP reply.
+	HelpHeader string `json:"help_header"`
+}
+
+type routePattern struct {
+	Pattern string `json:"pattern"`
+	Service string `json:"service"`
+}
+
+func (r *routesConfig) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, r)
+}
+
+// Router picks a service for an incoming message by its keyword prefix
+// or a text pattern, the message origin is used when nothing matches.
+type Router struct {
+	keywords map[string]string
+	patterns []*compiledPattern
+	help     map[string]bool
+	helpText string
+	// primary is the keyword listed in help for each route.
+	primary map[string]string
+}
+
+func NewRouter(cfg *routesConfig) (r *Router, err error) {
+	r = &Router{
+		keywords: make(map[string]string, len(cfg.Keywords)),
+		help:     make(map[string]bool, len(cfg.Help)),
+		primary:  make(map[string]string),
+	}
+	for kw, name := range cfg.Keywords {
+		if !service.Supported(name) && !builtinRoutes[name] {
+			return nil, errors.New("router: unknown service " + name + " for keyword " + kw)
+		}
+		r.keywords[strings.ToLower(kw)] = name
+	}
+	for _, p := range cfg.Patterns {
+		if !service.Supported(p.Service) {
+			return nil, errors.New("router: unknown service " + p.Service + " for pattern " + p.Pattern)
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, &compiledPattern{re: re, service: p.Service})
+	}
+	for _, kw := range cfg.Help {
+		r.help[strings.ToLower(kw)] = true
+	}
+	if len(r.help) < 1 {
+		r.help[helpRoute] = true
+	}
+	header := cfg.HelpHeader
+	if len(header) < 1 {
+		header = defaultHelpHeader
+	}
+	for kw, name := range r.keywords {
+		r.primary[name] = preferKeyword(r.primary[name], kw)
+	}
+	var help string
+	for kw := range r.help {
+		help = preferKeyword(help, kw)
+	}
+	r.primary[helpRoute] = help
+	r.helpText = r.buildHelp(header)
+	return
+}
+
+// preferKeyword picks the keyword to list: GSM 03.38 over the others,
+// then the shorter one, then the alphabetically first.
+func preferKeyword(a, b string) string {
+	switch {
+	case len(a) < 1:
+		return b
+	case isGSM7(a) != isGSM7(b):
+		if isGSM7(a) {
+			return a
+		}
+		return b
+	case len(a) != len(b):
+		if len(a) < len(b) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+// Route returns the service name and the query text with the keyword stripped.
+func (r *Router) Route(origin, text string) (name, query string) {
+	text = strings.TrimSpace(text)
+	kw, rest := splitKeyword(text)
+	kw = strings.ToLower(kw)
+	if r.help[kw] {
+		return helpRoute, rest
+	}
+	if name, ok := r.keywords[kw]; ok {
+		return name, rest
+	}
+	for _, p := range r.patterns {
+		if p.re.MatchString(text) {
+			return p.service, text
+		}
+	}
+	return origin, text
+}
+
+// HelpText returns the HELP reply listing a keyword of every route.
+func (r *Router) HelpText() string {
+	return r.helpText
+}
+
+// Usage returns the reply to a route keyword sent without a query,
+// the HELP reply if the route has no keyword.
+func (r *Router) Usage(name string) string {
+	kw, ok := r.primary[name]
+	if !ok {
+		return r.helpText
+	}
+	return fmt.Sprintf("Send %s <query> for %s", kw, name)
+}
+
+func (r *Router) buildHelp(header string) string {
+	names := make([]string, 0, len(r.primary))
+	for name := range r.primary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	kws := make([]string, 0, len(names))
+	for _, name := range names {
+		kws = append(kws, r.primary[name])
+	}
+	return header + " " + strings.Join(kws, ", ")
+}
+
+func isGSM7(text string) bool {
+	return smsenc.Detect(text) == smsenc.GSM7
+}
+
+type compiledPattern struct {
+	re      *regexp.Regexp
+	service string
+}
+
+func splitKeyword(text string) (kw, rest string) {
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i:])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/smsenc"
+)
+
+var testRoutes = &routesConfig{
+	Keywords: map[string]string{
+		"wiki": "wikipedia",
+		"вики": "wikipedia",
+		"calc": "wolfram",
+		"more": "more",
+		"ещё":  "more",
+	},
+	Patterns: []routePattern{
+		{Pattern: `(?i)^(\d+\s*)?(usd|eur)$`, Service: "currency"},
+	},
+	Help: []string{"help", "помощь"},
+}
+
+func TestRoute(t *testing.T) {
+	r, err := NewRouter(testRoutes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		origin, text string
+		name, query  string
+	}{
+		{"wolfram", "wiki Moscow", "wikipedia", "Moscow"},
+		{"wolfram", "ВИКИ   Москва", "wikipedia", "Москва"},
+		{"wikipedia", "calc 2+2", "wolfram", "2+2"},
+		{"wolfram", "wiki", "wikipedia", ""},
+		{"wolfram", "  ещё ", "more", ""},
+		{"wolfram", "100 usd", "currency", "100 usd"},
+		{"wolfram", "EUR", "currency", "EUR"},
+		{"wolfram", "help", helpRoute, ""},
+		{"wolfram", "Помощь wiki", helpRoute, "wiki"},
+		{"wolfram", "wikipedia Moscow", "wolfram", "wikipedia Moscow"},
+		{"wikipedia", "100 usd in rub", "wikipedia", "100 usd in rub"},
+	}
+	for _, tt := range tests {
+		name, query := r.Route(tt.origin, tt.text)
+		assert.Equal(t, tt.name, name, tt.text)
+		assert.Equal(t, tt.query, query, tt.text)
+	}
+}
+
+func TestRouterHelp(t *testing.T) {
+	r, err := NewRouter(testRoutes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	help := r.HelpText()
+	assert.Equal(t, "Send <keyword> <query>: help, more, wiki, calc", help)
+	assert.Equal(t, smsenc.GSM7, smsenc.Detect(help))
+	assert.Equal(t, 1, smsenc.Segments(help))
+
+	assert.Equal(t, "Send wiki <query> for wikipedia", r.Usage("wikipedia"))
+	assert.Equal(t, help, r.Usage("currency"))
+}
+
+func TestRouterUnknown(t *testing.T) {
+	_, err := NewRouter(&routesConfig{Keywords: map[string]string{"x": "nope"}})
+	assert.Error(t, err)
+	_, err = NewRouter(&routesConfig{Patterns: []routePattern{{Pattern: "x", Service: "nope"}}})
+	assert.Error(t, err)
+	_, err = NewRouter(&routesConfig{Patterns: []routePattern{{Pattern: "(", Service: "wolfram"}}})
+	assert.Error(t, err)
+}
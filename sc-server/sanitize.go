@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/codegangsta/cli"
+	"github.com/jinzhu/gorm"
+	"github.com/xlab/smscloud/sanitize"
+)
+
+// dryRunSanitize is the sanitize command action, it runs the configured
+// pipeline over the latest stored replies and prints the ones that differ.
+func dryRunSanitize(c *cli.Context) {
+	cfg := &sanitize.Config{}
+	if err := cfg.ReadFromFile(c.GlobalString("sanitize-cfg")); err != nil {
+		log.Fatalln(err)
+	}
+	pipeline, err := sanitize.New(cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	dbCfg := &dbConfig{}
+	if err = dbCfg.ReadFromFile(c.GlobalString("db-cfg")); err != nil {
+		log.Fatalln(err)
+	}
+	db, err := gorm.Open("postgres", dbCfg.DataSourceName())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	query := db.Where("service_reply <> ''")
+	if svc := c.String("service"); len(svc) > 0 {
+		query = query.Where("service = ?", svc)
+	}
+	var reqs []*Request
+	if err = query.Order("id desc").Limit(c.Int("limit")).Find(&reqs).Error; err != nil {
+		log.Fatalln(err)
+	}
+	var changed int
+	for _, req := range reqs {
+		out := pipeline.Apply(req.Service, req.ServiceReply)
+		if out == req.ServiceReply {
+			continue
+		}
+		changed++
+		fmt.Printf("#%d %s\n- %s\n+ %s\n\n", req.Id, req.Service, req.ServiceReply, out)
+	}
+	fmt.Printf("%d of %d replies would change\n", changed, len(reqs))
+}
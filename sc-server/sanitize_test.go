@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/sanitize"
+)
+
+func TestSanitizeConfig(t *testing.T) {
+	cfg := &sanitize.Config{}
+	if !assert.NoError(t, cfg.ReadFromFile("sanitize.json")) {
+		return
+	}
+	p, err := sanitize.New(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// abbreviations must not turn a GSM 03.38 reply into UCS-2
+	for name, chain := range cfg.Chains {
+		for _, rule := range chain {
+			for unit, abbr := range rule.Units {
+				assert.True(t, isGSM7(abbr), "%s: %s -> %s", name, unit, abbr)
+			}
+		}
+	}
+	assert.Equal(t, "-5 deg C", p.Apply("wolfram", "-5 degrees Celsius"))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	schedulerInterval = 30 * time.Second
+	maxJobAttempts    = 3
+)
+
+const (
+	jobPending int8 = iota
+	jobDone
+	jobCancelled
+	jobFailed
+)
+
+// Job is a text scheduled to be sent to the address at DueAt.
+type Job struct {
+	Id        int64
+	Address   string `sql:"size:20"`
+	Text      string `sql:"size:160"`
+	JobStatus int8
+	Attempts  int
+	DueAt     time.Time
+	CreatedAt time.Time
+	SentAt    time.Time
+}
+
+// Scheduler keeps jobs in the database and dispatches them when due,
+// so pending jobs survive restarts.
+type Scheduler struct {
+	db   *gorm.DB
+	send func(to, text string) error
+	quit chan struct{}
+}
+
+func NewScheduler(db *gorm.DB, send func(to, text string) error) (s *Scheduler, err error) {
+	if err = db.AutoMigrate(Job{}).Error; err != nil {
+		return nil, err
+	}
+	s = &Scheduler{
+		db:   db,
+		send: send,
+		quit: make(chan struct{}),
+	}
+	return
+}
+
+// Schedule stores a new pending job.
+func (s *Scheduler) Schedule(addr, text string, due time.Time) (job *Job, err error) {
+	job = &Job{
+		Address:   addr,
+		Text:      text,
+		JobStatus: jobPending,
+		DueAt:     due,
+		CreatedAt: time.Now(),
+	}
+	if err = s.db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Pending lists pending jobs of the address ordered by due time.
+func (s *Scheduler) Pending(addr string) (jobs []*Job, err error) {
+	err = s.db.Where("address = ? AND job_status = ?", addr, jobPending).
+		Order("due_at").Find(&jobs).Error
+	return
+}
+
+// Cancel cancels a pending job if it belongs to the address.
+func (s *Scheduler) Cancel(addr string, id int64) (ok bool, err error) {
+	var job Job
+	if err = s.db.Where("id = ? AND address = ? AND job_status = ?", id, addr, jobPending).
+		First(&job).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return false, nil
+		}
+		return
+	}
+	job.JobStatus = jobCancelled
+	if err = s.db.Save(&job).Error; err != nil {
+		return
+	}
+	return true, nil
+}
+
+// Run dispatches due jobs until Stop is called.
+func (s *Scheduler) Run() {
+	t := time.NewTicker(schedulerInterval)
+	defer t.Stop()
+	s.dispatch()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-t.C:
+			s.dispatch()
+		}
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.quit)
+}
+
+func (s *Scheduler) dispatch() {
+	var jobs []*Job
+	if err := s.db.Where("job_status = ? AND due_at <= ?", jobPending, time.Now()).
+		Order("due_at").Find(&jobs).Error; err != nil {
+		log.Println("scheduler: unable to fetch jobs:", err)
+		return
+	}
+	for _, job := range jobs {
+		job.Attempts++
+		if err := s.send(job.Address, job.Text); err != nil {
+			log.Printf("scheduler: job %d failed: %s", job.Id, err.Error())
+			if d, ok := err.(*deferredError); ok {
+				// deferred by the budget, not an attempt
+				job.Attempts--
+				job.DueAt = d.limit.Until
+			} else if job.Attempts >= maxJobAttempts {
+				job.JobStatus = jobFailed
+			}
+		} else {
+			job.JobStatus = jobDone
+			job.SentAt = time.Now()
+		}
+		if err := s.db.Save(job).Error; err != nil {
+			log.Printf("scheduler: unable to save job %d: %s", job.Id, err.Error())
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Built-in routes that manage digest subscriptions.
+const (
+	subRoute   = "sub"
+	unsubRoute = "unsub"
+	stopRoute  = "stop"
+)
+
+const (
+	digestInterval   = time.Minute
+	minDigestReserve = 50 // messages left on the account
+	// digestRetry is how long subscriptions wait after a failed build or send.
+	digestRetry = 15 * time.Minute
+)
+
+const subUsage = "Формат: sub <тема> [ЧЧ:ММ], unsub <тема>, stop. Темы: "
+
+var (
+	errOptedOut     = errors.New("recipient has opted out")
+	errUnknownTopic = errors.New("digests: unknown topic")
+)
+
+// Subscription is a recurring daily digest for the address.
+type Subscription struct {
+	Id         int64
+	Address    string `sql:"size:20"`
+	Topic      string `sql:"size:20"`
+	SendAt     string `sql:"size:5"` // daily clock time, e.g. 09:00
+	NextAt     time.Time
+	LastSentAt time.Time
+	CreatedAt  time.Time
+}
+
+// OptOut marks an address that asked not to be messaged.
+type OptOut struct {
+	Id        int64
+	Address   string `sql:"size:20"`
+	CreatedAt time.Time
+}
+
+// Subscriptions stores digest subscriptions and opt-outs.
+type Subscriptions struct {
+	db      *gorm.DB
+	digests map[string]*Digest
+	// loc is the timezone of the send times.
+	loc *time.Location
+}
+
+func NewSubscriptions(db *gorm.DB, digests map[string]*Digest, loc *time.Location) (s *Subscriptions, err error) {
+	if err = db.AutoMigrate(Subscription{}, OptOut{}).Error; err != nil {
+		return nil, err
+	}
+	s = &Subscriptions{
+		db:      db,
+		digests: digests,
+		loc:     loc,
+	}
+	return
+}
+
+// Handle processes the sub, unsub and stop commands and returns a reply.
+func (s *Subscriptions) Handle(route, addr, text string) (reply string, err error) {
+	switch route {
+	case stopRoute:
+		return s.stop(addr)
+	case unsubRoute:
+		return s.unsubscribe(addr, strings.ToLower(text))
+	}
+	topic, sendAt := splitKeyword(text)
+	topic = strings.ToLower(topic)
+	digest, ok := s.digests[topic]
+	if !ok {
+		return subUsage + strings.Join(s.topics(), ", "), nil
+	}
+	if len(sendAt) < 1 {
+		sendAt = digest.SendAt
+	}
+	next, err := nextClock(sendAt, time.Now().In(s.loc))
+	if err != nil {
+		return subUsage + strings.Join(s.topics(), ", "), nil
+	}
+	// explicit subscription cancels the opt-out
+	if err = s.db.Where("address = ?", addr).Delete(OptOut{}).Error; err != nil {
+		return
+	}
+	var sub Subscription
+	if err = s.db.Where("address = ? AND topic = ?", addr, topic).First(&sub).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			return
+		}
+		sub = Subscription{
+			Address:   addr,
+			Topic:     topic,
+			CreatedAt: time.Now(),
+		}
+	}
+	sub.SendAt = sendAt
+	sub.NextAt = next
+	if err = s.db.Save(&sub).Error; err != nil {
+		return
+	}
+	return fmt.Sprintf("Подписка %s: ежедневно в %s. Отмена: stop", topic, sendAt), nil
+}
+
+func (s *Subscriptions) unsubscribe(addr, topic string) (reply string, err error) {
+	db := s.db.Where("address = ? AND topic = ?", addr, topic).Delete(Subscription{})
+	if err = db.Error; err != nil {
+		return
+	}
+	if db.RowsAffected < 1 {
+		return fmt.Sprintf("Подписка %s не найдена", topic), nil
+	}
+	return fmt.Sprintf("Подписка %s отменена", topic), nil
+}
+
+func (s *Subscriptions) stop(addr string) (reply string, err error) {
+	if err = s.db.Where("address = ?", addr).Delete(Subscription{}).Error; err != nil {
+		return
+	}
+	if s.OptedOut(addr) {
+		return "Все подписки отменены", nil
+	}
+	optOut := OptOut{
+		Address:   addr,
+		CreatedAt: time.Now(),
+	}
+	if err = s.db.Create(&optOut).Error; err != nil {
+		return
+	}
+	return "Все подписки отменены", nil
+}
+
+// OptedOut reports whether the address asked not to be messaged.
+func (s *Subscriptions) OptedOut(addr string) bool {
+	var optOut OptOut
+	if err := s.db.Where("address = ?", addr).First(&optOut).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			log.Println("subscriptions: unable to check opt-out:", err)
+		}
+		return false
+	}
+	return true
+}
+
+// Due lists subscriptions scheduled to be sent by now.
+func (s *Subscriptions) Due(now time.Time) (subs []*Subscription, err error) {
+	err = s.db.Where("next_at <= ?", now).Order("next_at").Find(&subs).Error
+	return
+}
+
+// Sent reschedules the subscription to its next daily run.
+func (s *Subscriptions) Sent(sub *Subscription, now time.Time) error {
+	sub.LastSentAt = now
+	return s.Postpone(sub, s.nextRun(sub, now))
+}
+
+// Postpone reschedules the subscription without sending it.
+func (s *Subscriptions) Postpone(sub *Subscription, next time.Time) error {
+	sub.NextAt = next
+	return s.db.Save(sub).Error
+}
+
+func (s *Subscriptions) nextRun(sub *Subscription, now time.Time) time.Time {
+	next, err := nextClock(sub.SendAt, now.In(s.loc))
+	if err != nil {
+		return now.Add(24 * time.Hour)
+	}
+	return next
+}
+
+func (s *Subscriptions) topics() []string {
+	list := make([]string, 0, len(s.digests))
+	for topic := range s.digests {
+		list = append(list, topic)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// runDigests dispatches due digest subscriptions every minute.
+func (m *MessageHandler) runDigests() {
+	t := time.NewTicker(digestInterval)
+	defer t.Stop()
+	for range t.C {
+		m.dispatchDigests()
+	}
+}
+
+func (m *MessageHandler) dispatchDigests() {
+	now := time.Now()
+	subs, err := m.subs.Due(now)
+	if err != nil {
+		log.Println("digests: unable to fetch subscriptions:", err)
+		return
+	}
+	if len(subs) < 1 {
+		return
+	}
+	reserve, err := m.getReserve()
+	if err != nil {
+		log.Println("digests: failed to get reserve:", err)
+		m.notifyError()
+		return
+	}
+	// every topic is built once per dispatch, failed ones included
+	texts := make(map[string]string)
+	failed := make(map[string]error)
+	for _, sub := range subs {
+		text, ok := texts[sub.Topic]
+		if !ok && failed[sub.Topic] == nil {
+			if text, err = m.buildDigest(sub.Topic); err != nil {
+				log.Printf("digests: unable to build %s: %s", sub.Topic, err.Error())
+				failed[sub.Topic] = err
+			} else {
+				texts[sub.Topic], ok = text, true
+			}
+		}
+		if !ok {
+			next := now.Add(digestRetry)
+			if failed[sub.Topic] == errUnknownTopic {
+				next = m.subs.nextRun(sub, now)
+			}
+			m.postponeDigest(sub, next)
+			continue
+		}
+		text = m.translits.Apply(sub.Address, text)
+		var n int
+		if _, n, err = m.smsCost(sub.Address, text); err != nil {
+			log.Println("digests: unable to get cost:", err)
+			continue
+		}
+		if reserve-n < minDigestReserve {
+			log.Printf("digests: reserve is too low (%d), sending deferred", reserve)
+			m.notifyReserve(reserve)
+			return
+		}
+		if err = m.sendNotice(sub.Address, text); err != nil {
+			log.Printf("digests: unable to send %s to %s: %s", sub.Topic, sub.Address, err.Error())
+			if _, deferred := err.(*deferredError); deferred {
+				return
+			}
+			if err != errOptedOut {
+				m.postponeDigest(sub, now.Add(digestRetry))
+				continue
+			}
+		} else {
+			reserve -= n
+		}
+		if err = m.subs.Sent(sub, now); err != nil {
+			log.Printf("digests: unable to save subscription %d: %s", sub.Id, err.Error())
+		}
+	}
+	m.notifyReserve(reserve)
+}
+
+// buildDigest builds the topic digest and wraps it into a single page.
+func (m *MessageHandler) buildDigest(topic string) (text string, err error) {
+	digest, known := m.subs.digests[topic]
+	if !known {
+		return "", errUnknownTopic
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	if text, err = digest.Build(ctx); err != nil {
+		return
+	}
+	return m.wrapReply(text), nil
+}
+
+func (m *MessageHandler) postponeDigest(sub *Subscription, next time.Time) {
+	if err := m.subs.Postpone(sub, next); err != nil {
+		log.Printf("digests: unable to save subscription %d: %s", sub.Id, err.Error())
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xlab/smscloud/translit"
+)
+
+// translitRoute is the built-in route that sets the sender's preference
+// for Cyrillic replies to be transliterated to Latin.
+const translitRoute = "translit"
+
+const translitOff = "off"
+
+const translitUsage = "Формат: translit on, off, gost или icao"
+
+var errTranslitTable = errors.New("unknown transliteration table")
+
+// TranslitPref is the sender's transliteration table name or off.
+type TranslitPref struct {
+	Id        int64
+	Address   string `sql:"size:20"`
+	Scheme    string `sql:"size:10"`
+	UpdatedAt time.Time
+}
+
+// Translits decides whether replies to the address are transliterated,
+// the default table is used for senders with no preference.
+type Translits struct {
+	db  *gorm.DB
+	def *translit.Table
+}
+
+// NewTranslits creates preferences with the named default table,
+// empty name means replies aren't transliterated by default.
+func NewTranslits(db *gorm.DB, def string) (t *Translits, err error) {
+	t = &Translits{db: db}
+	if len(def) > 0 {
+		if t.def = translit.Lookup(def); t.def == nil {
+			return nil, errTranslitTable
+		}
+	}
+	if err = db.AutoMigrate(TranslitPref{}).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Handle sets the sender's preference and returns a reply.
+func (t *Translits) Handle(addr, query string) (reply string, err error) {
+	var name string
+	switch arg := strings.ToLower(strings.TrimSpace(query)); arg {
+	case "":
+		var table *translit.Table
+		if table, err = t.Table(addr); err != nil {
+			return
+		}
+		if table == nil {
+			return "Транслитерация выключена. " + translitUsage, nil
+		}
+		return "Транслитерация: " + table.Name, nil
+	case "off", "выкл":
+		name = translitOff
+	case "on", "вкл":
+		name = translit.ICAO.Name
+		if t.def != nil {
+			name = t.def.Name
+		}
+	default:
+		if translit.Lookup(arg) == nil {
+			return translitUsage, nil
+		}
+		name = arg
+	}
+	var pref TranslitPref
+	if err = t.db.Where("address = ?", addr).First(&pref).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			return
+		}
+		pref = TranslitPref{Address: addr}
+	}
+	pref.Scheme = name
+	pref.UpdatedAt = time.Now()
+	if err = t.db.Save(&pref).Error; err != nil {
+		return
+	}
+	if name == translitOff {
+		return "Транслитерация выключена", nil
+	}
+	return "Ответы будут латиницей (" + name + ")", nil
+}
+
+// Table returns the table to be used for the address, nil if none.
+func (t *Translits) Table(addr string) (*translit.Table, error) {
+	var pref TranslitPref
+	if err := t.db.Where("address = ?", addr).First(&pref).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return t.def, nil
+		}
+		return nil, err
+	}
+	if pref.Scheme == translitOff {
+		return nil, nil
+	}
+	return translit.Lookup(pref.Scheme), nil
+}
+
+// For returns the table replies to the address are transliterated with,
+// the default is used if the preference can't be read.
+func (t *Translits) For(addr string) *translit.Table {
+	table, err := t.Table(addr)
+	if err != nil {
+		log.Printf("error reading transliteration preference of %s: %s", addr, err.Error())
+		return t.def
+	}
+	return table
+}
+
+// Apply transliterates the text if the address prefers so.
+func (t *Translits) Apply(addr, text string) string {
+	return transliterate(text, t.For(addr))
+}
+
+// transliterate converts the text with the table, if any.
+func transliterate(text string, table *translit.Table) string {
+	if table == nil {
+		return text
+	}
+	return translit.Convert(text, table)
+}
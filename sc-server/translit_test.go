@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/smsenc"
+	"github.com/xlab/smscloud/translit"
+)
+
+func TestReplyTextTranslit(t *testing.T) {
+	req := &Request{ServiceReply: "Да", Translit: translit.ICAO}
+	assert.Equal(t, "Da http://goo.gl/x", replyText(req, "http://goo.gl/x", 1))
+	// pages are cut from the reply as is
+	req.Page = "Нет"
+	assert.Equal(t, "Net", replyText(req, "", 1))
+
+	req = &Request{ServiceReply: "Да"}
+	assert.Equal(t, "Да", replyText(req, "", 1))
+}
+
+func TestPageReplyTranslit(t *testing.T) {
+	reply := strings.Repeat("Щука плывёт. ", 20)
+	page, next := pageReply(reply, 0, "", 1, translit.ICAO)
+	assert.True(t, strings.HasPrefix(reply, strings.TrimSuffix(page, "…")), "paged as is")
+	assert.Equal(t, 1, smsenc.Segments(transliterate(page, translit.ICAO)))
+	// the offset points into the reply, whatever the table
+	rest, _ := pageReply(reply, next, "", 1, nil)
+	assert.True(t, strings.HasPrefix(rest, "Щука"), rest)
+}
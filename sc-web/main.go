@@ -82,6 +82,10 @@ func getTypeID(kind misc.NotifyType) []byte {
 		return []byte("received")
 	case misc.NotifyError:
 		return []byte("error")
+	case misc.NotifyDelivery:
+		return []byte("delivery")
+	case misc.NotifyBudget:
+		return []byte("budget")
 	}
 	panic("sc-web: unknown notify type id")
 }
@@ -95,7 +99,7 @@ func isCounter(kind misc.NotifyType) bool {
 }
 
 func isValue(kind misc.NotifyType) bool {
-	return kind == misc.NotifyReserve
+	return kind == misc.NotifyReserve || kind == misc.NotifyDelivery || kind == misc.NotifyBudget
 }
 
 func (s *State) IncCounter(kind misc.NotifyType) error {
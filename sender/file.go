@@ -0,0 +1,60 @@
+package sender
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xlab/smscloud/smsenc"
+)
+
+// fileBalance is what the file sink reports, so reserve checks always pass.
+const fileBalance = 1000
+
+// File appends messages to a file instead of sending them, it's meant
+// for development. Costs are estimated by the number of segments.
+type File struct {
+	cost float32
+
+	mux  sync.Mutex
+	w    io.Writer
+	next int64
+}
+
+// NewFile creates a sink appending to the file at path, stderr if empty.
+func NewFile(path string, cost float32) (f *File, err error) {
+	f = &File{cost: cost, w: os.Stderr}
+	if len(path) > 0 {
+		if f.w, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (f *File) Cost(to, text string) (cost float32, n int, err error) {
+	n = smsenc.Segments(text)
+	return float32(n) * f.cost, n, nil
+}
+
+func (f *File) Send(to, text string) (id string, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.next++
+	id = fmt.Sprintf("file-%d", f.next)
+	text = strings.Replace(text, "\n", `\n`, -1)
+	_, err = fmt.Fprintf(f.w, "%s %s to %s: %s\n", time.Now().Format(time.RFC3339), id, to, text)
+	return
+}
+
+// Status reports every message as delivered once it's written.
+func (f *File) Status(id string) (status Status, err error) {
+	return StatusDelivered, nil
+}
+
+func (f *File) Balance() (balance float32, err error) {
+	return fileBalance, nil
+}
@@ -0,0 +1,195 @@
+package sender
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xlab/at"
+	"github.com/xlab/at/sms"
+	"github.com/xlab/smscloud/smsenc"
+)
+
+const ussdTimeout = 30 * time.Second
+
+var (
+	ErrNoUSSD    = errors.New("sender: no balance USSD is set")
+	ErrBalance   = errors.New("sender: unable to parse the balance reply")
+	ErrTimeout   = errors.New("sender: modem didn't reply in time")
+	ErrMessageId = errors.New("sender: bad message id")
+)
+
+var amount = regexp.MustCompile(`-?\d+([.,]\d+)?`)
+
+// Modem sends messages via a GSM modem attached to the server,
+// costs are estimated by the number of segments.
+//
+// Status reports are requested for the sent messages, the message IDs
+// are their references. Statuses are kept in memory, so the ones
+// of messages sent before a restart stay pending.
+type Modem struct {
+	cfg *Config
+
+	mux sync.Mutex
+	dev *at.Device
+	// statuses by reference, a reference is reused after 256 messages
+	statuses map[byte]Status
+}
+
+func NewModem(cfg *Config) *Modem {
+	return &Modem{cfg: cfg, statuses: make(map[byte]Status)}
+}
+
+// device returns the opened device, it's reopened after a disconnect.
+func (m *Modem) device() (*at.Device, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.dev != nil {
+		return m.dev, nil
+	}
+	dev := &at.Device{
+		CommandPort: m.cfg.CommandPortPath,
+		NotifyPort:  m.cfg.NotifyPortPath,
+	}
+	if err := dev.Open(); err != nil {
+		return nil, err
+	}
+	if err := dev.Init(at.DeviceE173()); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	go func() {
+		dev.Watch()
+		m.drop(dev)
+	}()
+	go m.watchReports(dev)
+	m.dev = dev
+	return dev, nil
+}
+
+// watchReports records the statuses reported by the device until it's closed.
+func (m *Modem) watchReports(dev *at.Device) {
+	for {
+		select {
+		case <-dev.Closed():
+			return
+		case r := <-dev.StatusReports():
+			m.mux.Lock()
+			m.statuses[r.MessageReference] = ReportStatus(r.Status)
+			m.mux.Unlock()
+		}
+	}
+}
+
+// drop forgets the device if it's still the current one.
+func (m *Modem) drop(dev *at.Device) {
+	m.mux.Lock()
+	if m.dev == dev {
+		m.dev = nil
+	}
+	m.mux.Unlock()
+}
+
+func (m *Modem) Cost(to, text string) (cost float32, n int, err error) {
+	n = smsenc.Segments(text)
+	return float32(n) * m.cfg.MessageCost, n, nil
+}
+
+func (m *Modem) Send(to, text string) (id string, err error) {
+	var dev *at.Device
+	if dev, err = m.device(); err != nil {
+		return
+	}
+	ref, err := SendSMS(dev, text, sms.PhoneNumber(to))
+	if err != nil {
+		dev.Close()
+		m.drop(dev)
+		return
+	}
+	m.mux.Lock()
+	m.statuses[ref] = StatusPending
+	m.mux.Unlock()
+	return strconv.Itoa(int(ref)), nil
+}
+
+// Status returns the reported status of the message,
+// it's pending until the report comes.
+func (m *Modem) Status(id string) (Status, error) {
+	ref, err := strconv.ParseUint(id, 10, 8)
+	if err != nil {
+		return StatusPending, ErrMessageId
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.statuses[byte(ref)], nil
+}
+
+// SendSMS sends the text with the device requesting a status report
+// and returns the message reference the report comes with.
+func SendSMS(dev *at.Device, text string, to sms.PhoneNumber) (ref byte, err error) {
+	msg := sms.Message{
+		Type:                sms.MessageTypes.Submit,
+		Encoding:            sms.Encodings.Gsm7Bit,
+		Text:                text,
+		Address:             to,
+		StatusReportRequest: true,
+	}
+	if smsenc.Detect(text) != smsenc.GSM7 {
+		msg.Encoding = sms.Encodings.UCS2
+	}
+	n, octets, err := msg.PDU()
+	if err != nil {
+		return
+	}
+	return dev.Commands.CMGS(n, octets)
+}
+
+// ReportStatus returns the delivery status by the TP-Status of a status report.
+func ReportStatus(st byte) Status {
+	switch {
+	case st < 0x20:
+		return StatusDelivered
+	case st < 0x40:
+		// temporary error, the service centre is still trying
+		return StatusPending
+	case st == 0x46:
+		// the validity period has expired
+		return StatusExpired
+	}
+	return StatusFailed
+}
+
+// Balance queries the balance with USSD and takes the first amount of the reply.
+func (m *Modem) Balance() (balance float32, err error) {
+	if len(m.cfg.BalanceUSSD) < 1 {
+		return 0, ErrNoUSSD
+	}
+	var dev *at.Device
+	if dev, err = m.device(); err != nil {
+		return
+	}
+	if err = dev.SendUSSD(m.cfg.BalanceUSSD); err != nil {
+		return
+	}
+	select {
+	case reply := <-dev.UssdReply():
+		return parseBalance(string(reply))
+	case <-time.After(ussdTimeout):
+		return 0, ErrTimeout
+	}
+}
+
+func parseBalance(reply string) (balance float32, err error) {
+	s := amount.FindString(reply)
+	if len(s) < 1 {
+		return 0, ErrBalance
+	}
+	v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 32)
+	if err != nil {
+		return 0, ErrBalance
+	}
+	return float32(v), nil
+}
@@ -0,0 +1,80 @@
+// Package sender abstracts the way outgoing SMS are sent, so the server
+// can use the smsru gateway, a local GSM modem or a file for development.
+package sender
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+)
+
+// Backends.
+const (
+	SmsruBackend = "smsru"
+	ModemBackend = "modem"
+	FileBackend  = "file"
+)
+
+var ErrBackend = errors.New("sender: unknown backend")
+
+// SmsSender sends SMS and tells the costs and the account balance.
+type SmsSender interface {
+	// Cost estimates the cost and the number of messages the text takes.
+	Cost(to, text string) (cost float32, n int, err error)
+	// Send sends the text and returns a message ID if the backend has one.
+	Send(to, text string) (id string, err error)
+	// Balance returns the account balance.
+	Balance() (balance float32, err error)
+}
+
+// Status is the delivery status of a sent message.
+type Status int
+
+const (
+	StatusPending Status = iota
+	StatusDelivered
+	StatusFailed
+	StatusExpired
+)
+
+// StatusChecker is implemented by the senders that can tell
+// the delivery status of a message by its ID.
+type StatusChecker interface {
+	Status(id string) (Status, error)
+}
+
+type Config struct {
+	Backend string `json:"backend"`
+	// SmsruKey is the smsru API key, it's taken from the credentials.
+	SmsruKey string `json:"-"`
+	// Modem ports and the USSD request that replies with the balance.
+	CommandPortPath string `json:"command_port_path"`
+	NotifyPortPath  string `json:"notify_port_path"`
+	BalanceUSSD     string `json:"balance_ussd"`
+	// MessageCost is the price of a single message for the backends
+	// that can't tell it themselves.
+	MessageCost float32 `json:"message_cost"`
+	// Path is the file the file backend appends to, stderr if empty.
+	Path string `json:"path"`
+}
+
+func (c *Config) ReadFromFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, c)
+}
+
+// New creates the sender of the configured backend, smsru by default.
+func New(cfg *Config) (SmsSender, error) {
+	switch cfg.Backend {
+	case SmsruBackend, "":
+		return NewSmsru(cfg.SmsruKey), nil
+	case ModemBackend:
+		return NewModem(cfg), nil
+	case FileBackend:
+		return NewFile(cfg.Path, cfg.MessageCost)
+	}
+	return nil, ErrBackend
+}
@@ -0,0 +1,96 @@
+package sender
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox.log")
+	s, err := New(&Config{Backend: FileBackend, Path: path, MessageCost: 0.5})
+	if !assert.NoError(t, err) {
+		return
+	}
+	cost, n, err := s.Cost("+79991234567", strings.Repeat("я", 71))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, float32(1), cost)
+
+	id, err := s.Send("+79991234567", "hello\nworld")
+	assert.NoError(t, err)
+	assert.Equal(t, "file-1", id)
+	if checker, ok := s.(StatusChecker); assert.True(t, ok) {
+		status, err := checker.Status(id)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusDelivered, status)
+	}
+	data, err := ioutil.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(data), "file-1 to +79991234567: hello\\nworld\n")
+	}
+	balance, err := s.Balance()
+	assert.NoError(t, err)
+	assert.True(t, balance > 0)
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(&Config{})
+	assert.NoError(t, err)
+	assert.IsType(t, &Smsru{}, s)
+	s, err = New(&Config{Backend: ModemBackend})
+	assert.NoError(t, err)
+	assert.IsType(t, &Modem{}, s)
+	_, err = New(&Config{Backend: "pigeon"})
+	assert.Equal(t, ErrBackend, err)
+}
+
+func TestParseBalance(t *testing.T) {
+	balance, err := parseBalance("Баланс:123,45р. Подключите услугу")
+	assert.NoError(t, err)
+	assert.Equal(t, float32(123.45), balance)
+	balance, err = parseBalance("Balance -5.10 rub")
+	assert.NoError(t, err)
+	assert.Equal(t, float32(-5.1), balance)
+	_, err = parseBalance("Сервис недоступен")
+	assert.Equal(t, ErrBalance, err)
+}
+
+func TestReportStatus(t *testing.T) {
+	tests := []struct {
+		st     byte
+		status Status
+	}{
+		{0x00, StatusDelivered},
+		{0x02, StatusDelivered},
+		{0x30, StatusPending},
+		{0x41, StatusFailed},
+		{0x46, StatusExpired},
+		{0x62, StatusFailed},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.status, ReportStatus(tt.st), "%#x", tt.st)
+	}
+}
+
+func TestModemStatus(t *testing.T) {
+	m := NewModem(&Config{})
+	m.statuses[42] = StatusDelivered
+	status, err := m.Status("42")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusDelivered, status)
+	status, err = m.Status("7")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, status, "not reported yet")
+	_, err = m.Status("300")
+	assert.Equal(t, ErrMessageId, err)
+}
@@ -0,0 +1,53 @@
+package sender
+
+import "github.com/xlab/smsru"
+
+// Smsru sends messages via the sms.ru gateway.
+type Smsru struct {
+	api *smsru.Api
+}
+
+func NewSmsru(key string) *Smsru {
+	return &Smsru{api: smsru.NewApi(key)}
+}
+
+func (s *Smsru) Cost(to, text string) (cost float32, n int, err error) {
+	sms := smsru.Sms{
+		To:   to,
+		Text: text,
+	}
+	return s.api.SmsCost(&sms)
+}
+
+func (s *Smsru) Send(to, text string) (id string, err error) {
+	sms := smsru.Sms{
+		To:   to,
+		Text: text,
+	}
+	var ids []string
+	if ids, err = s.api.SmsSend(&sms); err != nil || len(ids) < 1 {
+		return
+	}
+	return ids[0], nil
+}
+
+// Status maps the sms.ru status codes to delivery statuses.
+func (s *Smsru) Status(id string) (status Status, err error) {
+	var code int
+	if code, err = s.api.SmsStatus(id); err != nil {
+		return
+	}
+	switch code {
+	case 103:
+		return StatusDelivered, nil
+	case 104:
+		return StatusExpired, nil
+	case 100, 101, 102:
+		return StatusPending, nil
+	}
+	return StatusFailed, nil
+}
+
+func (s *Smsru) Balance() (balance float32, err error) {
+	return s.api.MyBalance()
+}
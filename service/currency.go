@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xlab/smscloud/currency"
+)
+
+var ErrCurrencyQuery = errors.New("currency: unable to parse query")
+
+// currencyQuery matches "eur", "100 usd", "usd rub" and "100 usd to eur".
+var currencyQuery = regexp.MustCompile(
+	`^(\d+(?:[.,]\d+)?)?\s*([a-zA-Z]{3})(?:\s+(?:in\s+|to\s+)?([a-zA-Z]{3}))?$`,
+)
+
+func init() {
+	Register("currency", func(cfg *Config) (Service, error) {
+		api := currency.NewApi()
+		api.Client = cfg.Client
+		if len(cfg.BaseURL) > 0 {
+			if err := api.SetBaseURL(cfg.BaseURL); err != nil {
+				return nil, err
+			}
+		}
+		return &currencyService{api: api}, nil
+	})
+}
+
+type currencyService struct {
+	api *currency.Api
+}
+
+func (c *currencyService) Query(ctx context.Context, input string) (reply string, link *url.URL, err error) {
+	amount, from, to, err := parseCurrencyQuery(input)
+	if err != nil {
+		return
+	}
+	var res *currency.DailyRates
+	if res, err = c.api.Query(ctx); err != nil {
+		return
+	}
+	var value float64
+	if value, err = res.Convert(amount, from, to); err != nil {
+		return
+	}
+	reply = fmt.Sprintf("%s %s = %s %s (CBR %s)",
+		strconv.FormatFloat(amount, 'f', -1, 64), from,
+		strconv.FormatFloat(value, 'f', 2, 64), to, res.Date)
+	return
+}
+
+func parseCurrencyQuery(input string) (amount float64, from, to string, err error) {
+	m := currencyQuery.FindStringSubmatch(strings.TrimSpace(input))
+	if m == nil {
+		err = ErrCurrencyQuery
+		return
+	}
+	amount = 1
+	if len(m[1]) > 0 {
+		if amount, err = strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64); err != nil {
+			return
+		}
+	}
+	from, to = strings.ToUpper(m[2]), strings.ToUpper(m[3])
+	if len(to) < 1 {
+		to = currency.RUB
+		if from == currency.RUB {
+			to = "USD"
+		}
+	}
+	return
+}
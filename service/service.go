@@ -0,0 +1,111 @@
+// Package service defines the interface every SmsCloud backend implements
+// and the registry both sc-server and sc-client read supported names from.
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+var ErrUnknown = errors.New("service: unknown service")
+
+// Service answers a text query. Link is an optional reference to the
+// full answer, it is nil when there is nothing to point to.
+// The query should be abandoned once ctx is done.
+type Service interface {
+	Query(ctx context.Context, input string) (reply string, link *url.URL, err error)
+}
+
+// Ambiguous is returned by a service when the input matches several
+// distinct answers, the user is expected to pick one of the options.
+type Ambiguous struct {
+	Options []string
+}
+
+func (a *Ambiguous) Error() string {
+	return "service: ambiguous input"
+}
+
+// Suggestion is returned by a service when nothing was found for the input,
+// Text is a hint for the sender, e.g. a spelling correction. It is a valid
+// reply but not an answer to the query, so it shouldn't be cached.
+type Suggestion struct {
+	Text string
+}
+
+func (s *Suggestion) Error() string {
+	return "service: no answer, suggestion given"
+}
+
+// Config carries the settings a backend needs to be instantiated.
+type Config struct {
+	Key      string // API key, if required
+	Location string // origin location hint
+	// Languages is the fallback order of content languages,
+	// used by services that support more than one.
+	Languages []string
+	// Client is shared by the services to make requests, optional.
+	Client *http.Client
+	// BaseURL overrides the API endpoint, optional.
+	BaseURL string
+}
+
+// Factory creates a configured service instance.
+type Factory func(cfg *Config) (Service, error)
+
+var (
+	mux      sync.RWMutex
+	registry = make(map[string]Factory)
+)
+
+// Register makes a service available by the provided name.
+// It panics if Register is called twice with the same name or if factory is nil.
+func Register(name string, factory Factory) {
+	mux.Lock()
+	defer mux.Unlock()
+	if factory == nil {
+		panic("service: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("service: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Supported reports whether a service with the given name is registered.
+func Supported(name string) bool {
+	mux.RLock()
+	defer mux.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+// Names returns a sorted list of the registered service names.
+func Names() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+	list := make([]string, 0, len(registry))
+	for name := range registry {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// New instantiates the named service using the config provided.
+func New(name string, cfg *Config) (Service, error) {
+	mux.RLock()
+	factory, ok := registry[name]
+	mux.RUnlock()
+	if !ok {
+		return nil, ErrUnknown
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/wikipedia"
+)
+
+type echoService struct{}
+
+func (echoService) Query(ctx context.Context, input string) (string, *url.URL, error) {
+	return input, nil, nil
+}
+
+func TestRegistry(t *testing.T) {
+	assert.True(t, Supported("wolfram"))
+	assert.True(t, Supported("wikipedia"))
+	assert.False(t, Supported("echo"))
+
+	Register("echo", func(cfg *Config) (Service, error) {
+		return echoService{}, nil
+	})
+	// keep the global registry clean for repeated runs
+	defer func() {
+		mux.Lock()
+		delete(registry, "echo")
+		mux.Unlock()
+	}()
+	assert.Contains(t, Names(), "echo")
+	assert.Panics(t, func() {
+		Register("echo", func(cfg *Config) (Service, error) {
+			return echoService{}, nil
+		})
+	})
+
+	svc, err := New("echo", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	reply, link, err := svc.Query(context.Background(), "ping")
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", reply)
+	assert.Nil(t, link)
+
+	_, err = New("nope", nil)
+	assert.Equal(t, ErrUnknown, err)
+}
+
+func TestParseCurrencyQuery(t *testing.T) {
+	amount, from, to, err := parseCurrencyQuery("100 usd")
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, amount)
+	assert.Equal(t, "USD", from)
+	assert.Equal(t, "RUB", to)
+
+	amount, from, to, err = parseCurrencyQuery("eur")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, amount)
+	assert.Equal(t, "EUR", from)
+	assert.Equal(t, "RUB", to)
+
+	amount, from, to, err = parseCurrencyQuery("2,5 usd to eur")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, amount)
+	assert.Equal(t, "USD", from)
+	assert.Equal(t, "EUR", to)
+
+	_, _, _, err = parseCurrencyQuery("what is the rouble")
+	assert.Equal(t, ErrCurrencyQuery, err)
+}
+
+func TestDetectLanguage(t *testing.T) {
+	lang, ok := detectLanguage("лопата")
+	assert.True(t, ok)
+	assert.EqualValues(t, "ru", lang)
+	lang, ok = detectLanguage("shovel 2014")
+	assert.True(t, ok)
+	assert.EqualValues(t, "en", lang)
+	lang, ok = detectLanguage("Αθήνα")
+	assert.True(t, ok)
+	assert.EqualValues(t, "el", lang)
+	_, ok = detectLanguage("42!")
+	assert.False(t, ok)
+
+	w := &wikipediaService{languages: defaultLanguages}
+	assert.Equal(t, []wikipedia.Language{"ru", "en"}, w.order("лопата"))
+	assert.Equal(t, []wikipedia.Language{"en", "ru"}, w.order("shovel"))
+	assert.Equal(t, []wikipedia.Language{"ka", "en", "ru"}, w.order("თბილისი"))
+}
+
+func TestWolframSuggestion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<queryresult success='false' error='false' numpods='0'>
+ <didyoumeans count='1'>
+  <didyoumean score='0.415939' level='medium'>weather</didyoumean>
+ </didyoumeans>
+</queryresult>`)
+	}))
+	defer ts.Close()
+	svc, err := New("wolfram", &Config{BaseURL: ts.URL})
+	if !assert.NoError(t, err) {
+		return
+	}
+	reply, link, err := svc.Query(context.Background(), "wether")
+	assert.Empty(t, reply)
+	assert.NotNil(t, link)
+	if sug, ok := err.(*Suggestion); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, "Возможно, вы имели в виду: weather", sug.Text)
+	}
+}
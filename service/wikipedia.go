@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xlab/smscloud/wikipedia"
+)
+
+// minDescription is the opensearch snippet length in runes below which
+// the article intro extract is fetched instead.
+const minDescription = 60
+
+// maxOptions limits the number of articles offered on disambiguation.
+const maxOptions = 5
+
+// defaultLanguages is the fallback order if none is configured.
+var defaultLanguages = []wikipedia.Language{wikipedia.EN, wikipedia.RU}
+
+// scriptLanguages maps writing scripts to the wiki tried first for them.
+var scriptLanguages = []struct {
+	script *unicode.RangeTable
+	lang   wikipedia.Language
+}{
+	{unicode.Latin, wikipedia.EN},
+	{unicode.Cyrillic, wikipedia.RU},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Armenian, "hy"},
+	{unicode.Georgian, "ka"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+}
+
+func init() {
+	Register("wikipedia", func(cfg *Config) (Service, error) {
+		w := &wikipediaService{
+			api:       wikipedia.NewApi(),
+			languages: defaultLanguages,
+		}
+		w.api.Client = cfg.Client
+		if len(cfg.BaseURL) > 0 {
+			w.api.SetBaseURL(cfg.BaseURL)
+		}
+		if len(cfg.Languages) > 0 {
+			w.languages = make([]wikipedia.Language, 0, len(cfg.Languages))
+			for _, code := range cfg.Languages {
+				lang := wikipedia.Language(code)
+				if !lang.Valid() {
+					return nil, errors.New("wikipedia: invalid language code " + code)
+				}
+				w.languages = append(w.languages, lang)
+			}
+		}
+		return w, nil
+	})
+}
+
+type wikipediaService struct {
+	api       *wikipedia.Api
+	languages []wikipedia.Language
+}
+
+// Query searches the wiki matching the input's script first
+// and then falls back to the configured languages in order.
+func (w *wikipediaService) Query(ctx context.Context, input string) (reply string, link *url.URL, err error) {
+	var uri string
+	for _, lang := range w.order(input) {
+		reply, uri, err = w.query(ctx, lang, input)
+		if _, ok := err.(*Ambiguous); ok {
+			return "", nil, err
+		}
+		if err == nil && len(reply) > 0 {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	if len(uri) > 0 {
+		link, _ = url.ParseRequestURI(uri)
+	}
+	return
+}
+
+// order returns languages to try for the input, the detected one goes first.
+func (w *wikipediaService) order(input string) []wikipedia.Language {
+	list := make([]wikipedia.Language, 0, len(w.languages)+1)
+	if lang, ok := detectLanguage(input); ok {
+		list = append(list, lang)
+	}
+	for _, lang := range w.languages {
+		if len(list) > 0 && list[0] == lang {
+			continue
+		}
+		list = append(list, lang)
+	}
+	return list
+}
+
+// detectLanguage picks a language by the prevailing script of the letters.
+func detectLanguage(input string) (lang wikipedia.Language, ok bool) {
+	counts := make(map[wikipedia.Language]int)
+	var best int
+	for _, r := range input {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.script, r) {
+				counts[s.lang]++
+				if counts[s.lang] > best {
+					best, lang = counts[s.lang], s.lang
+				}
+				break
+			}
+		}
+	}
+	return lang, best > 0
+}
+
+// query answers with the top suggestion, if it's a disambiguation page
+// the other suggestions are returned as options to choose from.
+func (w *wikipediaService) query(ctx context.Context, lang wikipedia.Language, input string) (reply, uri string, err error) {
+	var res *wikipedia.SearchSuggestion
+	if res, err = w.api.Search(ctx, lang, input, maxOptions+1); err != nil {
+		// not wrapped, so the server can tell transport errors
+		return
+	}
+	items := res.Items
+	if len(items) < 1 {
+		return
+	}
+	page := w.page(ctx, lang, items[0].Text)
+	if page != nil && page.Disambiguation() && len(items) > 1 {
+		if len(items) > 2 {
+			options := make([]string, 0, len(items)-1)
+			for _, it := range items[1:] {
+				options = append(options, it.Text)
+			}
+			err = &Ambiguous{Options: options}
+			return
+		}
+		// the only other suggestion is the answer
+		items = items[1:]
+		page = w.page(ctx, lang, items[0].Text)
+	}
+	reply = items[0].Description
+	uri = items[0].URL
+	if utf8.RuneCountInString(reply) < minDescription && page != nil {
+		if len(page.Extract) > len(reply) {
+			reply = page.Extract
+		}
+	}
+	return
+}
+
+// page returns the article intro and props or nil if it's unavailable.
+func (w *wikipediaService) page(ctx context.Context, lang wikipedia.Language, title string) *wikipedia.Page {
+	res, err := w.api.Extract(ctx, lang, title)
+	if err != nil {
+		return nil
+	}
+	return res.Page()
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/xlab/smscloud/wolfram"
+)
+
+const wolframUserURI = "http://www.wolframalpha.com/input/"
+
+func init() {
+	Register("wolfram", func(cfg *Config) (Service, error) {
+		api := wolfram.NewApi(cfg.Key, cfg.Location)
+		api.Client = cfg.Client
+		if len(cfg.BaseURL) > 0 {
+			if err := api.SetBaseURL(cfg.BaseURL); err != nil {
+				return nil, err
+			}
+		}
+		return &wolframService{api: api}, nil
+	})
+}
+
+type wolframService struct {
+	api *wolfram.Api
+}
+
+func (w *wolframService) Query(ctx context.Context, input string) (reply string, link *url.URL, err error) {
+	var res *wolfram.QueryResult
+	if res, err = w.api.Query(ctx, input); err != nil {
+		if err == wolfram.ErrUnknown {
+			// nothing computed, suggest something if possible
+			return "", wolframURL(input), &Suggestion{Text: suggest(res)}
+		}
+		return
+	}
+	link = wolframURL(input)
+	if pod := res.Result(); pod != nil {
+		reply = pod.Text()
+	}
+	return
+}
+
+func suggest(res *wolfram.QueryResult) string {
+	if s := res.Suggestion(); len(s) > 0 {
+		return "Возможно, вы имели в виду: " + s
+	}
+	if len(res.Tips) > 0 {
+		return res.Tips[0].Text
+	}
+	return ""
+}
+
+func wolframURL(input string) *url.URL {
+	v := url.Values{}
+	v.Set("i", input)
+	u, _ := url.ParseRequestURI(wolframUserURI)
+	u.RawQuery = v.Encode()
+	return u
+}
@@ -0,0 +1,147 @@
+// Package smsenc implements SMS length calculations as per GSM 03.38:
+// a text is sent in the GSM 7-bit default alphabet if every character
+// belongs to it or its extension table, otherwise it's sent in UCS-2.
+package smsenc
+
+// Encoding is an SMS data coding.
+type Encoding int
+
+const (
+	GSM7 Encoding = iota
+	UCS2
+)
+
+func (e Encoding) String() string {
+	if e == GSM7 {
+		return "GSM-7"
+	}
+	return "UCS-2"
+}
+
+// Segment sizes in septets for GSM-7 and in 16-bit units for UCS-2,
+// concatenated segments lose room to the user data header.
+const (
+	GSM7Single = 160
+	GSM7Multi  = 153
+	UCS2Single = 70
+	UCS2Multi  = 67
+)
+
+// basic is the GSM 03.38 default alphabet, the escape code 0x1B is left out.
+var basic = map[rune]bool{}
+
+// extension is the GSM 03.38 extension table, such characters take two septets.
+var extension = map[rune]bool{}
+
+func init() {
+	for _, r := range "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà" {
+		basic[r] = true
+	}
+	for _, r := range "\f^{}\\[~]|€" {
+		extension[r] = true
+	}
+}
+
+// IsGSM7 checks if the rune can be sent in the GSM 7-bit alphabet.
+func IsGSM7(r rune) bool {
+	return basic[r] || extension[r]
+}
+
+// Detect returns the encoding the text has to be sent in.
+func Detect(text string) Encoding {
+	for _, r := range text {
+		if !IsGSM7(r) {
+			return UCS2
+		}
+	}
+	return GSM7
+}
+
+// size returns the number of units the rune takes in the encoding.
+func size(r rune, enc Encoding) int {
+	if enc == GSM7 {
+		if extension[r] {
+			return 2
+		}
+		return 1
+	}
+	if r > 0xFFFF {
+		return 2 // surrogate pair
+	}
+	return 1
+}
+
+// Units returns the length of the text in septets or 16-bit units
+// along with the encoding.
+func Units(text string) (n int, enc Encoding) {
+	enc = Detect(text)
+	for _, r := range text {
+		n += size(r, enc)
+	}
+	return
+}
+
+// Capacity returns how many units fit into the number of segments.
+func Capacity(enc Encoding, segments int) int {
+	switch {
+	case segments < 1:
+		return 0
+	case segments == 1 && enc == GSM7:
+		return GSM7Single
+	case segments == 1:
+		return UCS2Single
+	case enc == GSM7:
+		return GSM7Multi * segments
+	default:
+		return UCS2Multi * segments
+	}
+}
+
+// Segments returns the number of SMS the text will be sent in.
+func Segments(text string) int {
+	n, enc := Units(text)
+	switch {
+	case n == 0:
+		return 1
+	case n <= Capacity(enc, 1):
+		return 1
+	case enc == GSM7:
+		return (n + GSM7Multi - 1) / GSM7Multi
+	default:
+		return (n + UCS2Multi - 1) / UCS2Multi
+	}
+}
+
+// Truncate returns the longest prefix of the text that fits into the number
+// of segments with reserve units left free, e.g. for a link to be appended.
+// Extension characters and surrogate pairs are never split.
+func Truncate(text string, segments, reserve int) string {
+	if n, enc := Units(text); n+reserve <= Capacity(enc, segments) {
+		return text
+	}
+	// GSM-7 holds more, so it's used as far as the text allows
+	gsm, stopped := cut(text, GSM7, Capacity(GSM7, segments)-reserve)
+	if !stopped {
+		return gsm
+	}
+	if ucs, _ := cut(text, UCS2, Capacity(UCS2, segments)-reserve); len(ucs) > len(gsm) {
+		return ucs
+	}
+	return gsm
+}
+
+// cut returns the longest prefix within the limit of units in the encoding,
+// stopped is set if it ended on a character the encoding can't hold.
+func cut(text string, enc Encoding, limit int) (prefix string, stopped bool) {
+	var n int
+	for i, r := range text {
+		if enc == GSM7 && !IsGSM7(r) {
+			return text[:i], true
+		}
+		if n += size(r, enc); n > limit {
+			return text[:i], false
+		}
+	}
+	return text, false
+}
@@ -0,0 +1,70 @@
+package smsenc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, GSM7, Detect("Pi = 3.14159, see http://goo.gl/fbsS!"))
+	assert.Equal(t, GSM7, Detect("Price: 5€ [approx] {x|y} ~ ^\\"))
+	assert.Equal(t, UCS2, Detect("Лопата"))
+	assert.Equal(t, UCS2, Detect("100 USD — 7928 RUB"))
+	assert.Equal(t, UCS2, Detect("smile 😀"))
+}
+
+func TestUnits(t *testing.T) {
+	n, enc := Units("abc 123")
+	assert.Equal(t, 7, n)
+	assert.Equal(t, GSM7, enc)
+	n, enc = Units("a{b}€")
+	assert.Equal(t, 8, n)
+	assert.Equal(t, GSM7, enc)
+	n, enc = Units("ёж 😀")
+	assert.Equal(t, 5, n)
+	assert.Equal(t, UCS2, enc)
+}
+
+func TestSegments(t *testing.T) {
+	assert.Equal(t, 1, Segments(""))
+	assert.Equal(t, 1, Segments(strings.Repeat("a", 160)))
+	assert.Equal(t, 2, Segments(strings.Repeat("a", 161)))
+	assert.Equal(t, 2, Segments(strings.Repeat("a", 306)))
+	assert.Equal(t, 3, Segments(strings.Repeat("a", 307)))
+	assert.Equal(t, 2, Segments(strings.Repeat("a", 159)+"€"))
+	assert.Equal(t, 1, Segments(strings.Repeat("я", 70)))
+	assert.Equal(t, 2, Segments(strings.Repeat("я", 71)))
+	assert.Equal(t, 2, Segments(strings.Repeat("я", 134)))
+	assert.Equal(t, 3, Segments(strings.Repeat("я", 135)))
+}
+
+func TestTruncate(t *testing.T) {
+	short := "short text"
+	assert.Equal(t, short, Truncate(short, 1, 0))
+
+	latin := strings.Repeat("a", 400)
+	assert.Equal(t, latin[:160], Truncate(latin, 1, 0))
+	assert.Equal(t, latin[:306], Truncate(latin, 2, 0))
+	assert.Equal(t, latin[:285], Truncate(latin, 2, 21))
+
+	// extension characters are not split
+	ext := strings.Repeat("a", 159) + "€"
+	assert.Equal(t, ext[:159], Truncate(ext, 1, 0))
+
+	cyr := strings.Repeat("я", 200)
+	assert.Equal(t, strings.Repeat("я", 70), Truncate(cyr, 1, 0))
+	assert.Equal(t, strings.Repeat("я", 134), Truncate(cyr, 2, 0))
+
+	// a non GSM-7 character past the GSM-7 limit doesn't matter
+	mixed := strings.Repeat("a", 200) + "я"
+	assert.Equal(t, mixed[:160], Truncate(mixed, 1, 0))
+	// otherwise the text is cut for UCS-2
+	mixed = "я" + strings.Repeat("a", 200)
+	assert.Equal(t, mixed[:len("я")+69], Truncate(mixed, 1, 0))
+
+	// surrogate pairs are not split
+	emoji := strings.Repeat("a", 69) + "😀"
+	assert.Equal(t, emoji[:69], Truncate(emoji, 1, 0))
+}
@@ -0,0 +1,153 @@
+// Package summary cuts long replies down to a number of SMS segments
+// at sentence, clause or word boundaries, so numbers and their units
+// are never split and the cut off text is marked with an ellipsis.
+package summary
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xlab/smscloud/smsenc"
+)
+
+// Ellipsis markers, the GSM-7 one is used when the page fits in GSM-7,
+// since a single … would make the whole page UCS-2.
+const (
+	Ellipsis     = "…"
+	EllipsisGSM7 = "..."
+)
+
+type boundary int
+
+const (
+	wordBoundary boundary = iota
+	clauseBoundary
+	sentenceBoundary
+)
+
+// minShare is the part of the longest fitting page a page cut at a stronger
+// boundary must keep, otherwise a weaker boundary is preferred.
+const minShare = 2
+
+// Cut returns a page of the text that fits into the number of segments
+// with the suffix appended, e.g. a short link. The suffix isn't included
+// into the page. If the text is cut, n is the rune offset the rest of the
+// text starts at, otherwise it's the length of the text in runes.
+func Cut(text string, segments int, suffix string) (page string, n int) {
+	return CutConverted(text, segments, suffix, nil)
+}
+
+// CutConverted is Cut of a text that's sent converted, e.g. transliterated.
+// The page is returned as is and fits into the segments once converted.
+func CutConverted(text string, segments int, suffix string, convert func(string) string) (page string, n int) {
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	skip := utf8.RuneCountInString(text) - utf8.RuneCountInString(trimmed)
+	text = trimmed
+	fits := func(p string) bool {
+		if convert != nil {
+			p = convert(p)
+		}
+		return smsenc.Segments(p+suffix) <= segments
+	}
+	if whole := strings.TrimRightFunc(text, unicode.IsSpace); fits(whole) {
+		return whole, skip + utf8.RuneCountInString(text)
+	}
+	// ends and starts of the last fitting page per boundary kind
+	var ends, starts [sentenceBoundary + 1]int
+	for i := range ends {
+		ends[i] = -1
+	}
+	for _, b := range boundaries(text) {
+		if !fits(mark(text[:b.end], suffix)) {
+			break
+		}
+		ends[b.kind], starts[b.kind] = b.end, b.start
+	}
+	longest := -1
+	for _, end := range ends {
+		if end > longest {
+			longest = end
+		}
+	}
+	if longest < 0 {
+		// a single word doesn't fit, nothing to do but split it
+		end := len(smsenc.Truncate(text, segments, 0))
+		for end > 0 && !fits(mark(text[:end], suffix)) {
+			_, size := utf8.DecodeLastRuneInString(text[:end])
+			end -= size
+		}
+		return mark(text[:end], suffix), skip + utf8.RuneCountInString(text[:end])
+	}
+	for kind := sentenceBoundary; kind >= wordBoundary; kind-- {
+		if end := ends[kind]; end >= 0 && end*minShare >= longest {
+			return mark(text[:end], suffix), skip + utf8.RuneCountInString(text[:starts[kind]])
+		}
+	}
+	return "", skip
+}
+
+// mark trims the page end and appends the ellipsis.
+func mark(page, suffix string) string {
+	page = strings.TrimRightFunc(page, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(".,;:|-", r)
+	})
+	if smsenc.Detect(page+suffix) == smsenc.GSM7 {
+		return page + EllipsisGSM7
+	}
+	return page + Ellipsis
+}
+
+type cutPoint struct {
+	kind  boundary
+	end   int // byte offset the page ends at
+	start int // byte offset the rest starts at
+}
+
+// boundaries returns the points the text may be cut at in order. A page may
+// end before a whitespace run or after a table separator, but not right after
+// a number, so that its unit or the following digit group isn't cut off.
+func boundaries(text string) (points []cutPoint) {
+	var prev rune
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == '|':
+			points = append(points, cutPoint{kind: clauseBoundary, end: i, start: i + size})
+		case unicode.IsSpace(r):
+			j := i
+			newline := false
+			for j < len(text) {
+				s, n := utf8.DecodeRuneInString(text[j:])
+				if !unicode.IsSpace(s) {
+					break
+				}
+				newline = newline || s == '\n'
+				j += n
+			}
+			if kind, ok := kindAfter(prev, newline); ok && j < len(text) {
+				points = append(points, cutPoint{kind: kind, end: i, start: j})
+			}
+			prev = ' '
+			i = j
+			continue
+		}
+		prev = r
+		i += size
+	}
+	return
+}
+
+func kindAfter(prev rune, newline bool) (kind boundary, ok bool) {
+	switch {
+	case newline:
+		return sentenceBoundary, true
+	case unicode.IsDigit(prev):
+		return wordBoundary, false
+	case strings.ContainsRune(".!?", prev):
+		return sentenceBoundary, true
+	case strings.ContainsRune(",;:)", prev):
+		return clauseBoundary, true
+	}
+	return wordBoundary, true
+}
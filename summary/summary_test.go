@@ -0,0 +1,104 @@
+package summary
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/smsenc"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const link = " http://goo.gl/fbsS"
+
+// TestGolden cuts real Wolfram and Wikipedia replies from testdata/*.txt
+// and compares pages with the .golden files, run with -update to refresh them.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEmpty(t, inputs)
+	for _, name := range inputs {
+		data, err := ioutil.ReadFile(name)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		text := string(data)
+		buf := new(bytes.Buffer)
+		for _, segments := range []int{1, 2} {
+			for _, suffix := range []string{"", link} {
+				page, n := Cut(text, segments, suffix)
+				assert.True(t, smsenc.Segments(page+suffix) <= segments, name)
+				fmt.Fprintf(buf, "## segments=%d suffix=%q next=%d\n%s\n", segments, suffix, n, page)
+			}
+		}
+		golden := strings.TrimSuffix(name, ".txt") + ".golden"
+		if *update {
+			assert.NoError(t, ioutil.WriteFile(golden, buf.Bytes(), 0644))
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if assert.NoError(t, err) {
+			assert.Equal(t, string(want), buf.String(), name)
+		}
+	}
+}
+
+func TestCutFits(t *testing.T) {
+	page, n := Cut("  Pi is 3.14.\n", 1, link)
+	assert.Equal(t, "Pi is 3.14.", page)
+	assert.Equal(t, 14, n)
+}
+
+func TestCutNumbers(t *testing.T) {
+	text := "The distance is 149.6 million km " + strings.Repeat("and more words ", 20)
+	for size := 0; size < 40; size++ {
+		page, _ := Cut(text, 1, strings.Repeat("x", 130+size))
+		if strings.Contains(page, "149") {
+			assert.Contains(t, page, "149.6 million", page)
+		}
+	}
+	page, _ := Cut("Population is 13 274 285 people "+strings.Repeat("x", 200), 1, strings.Repeat("x", 130))
+	assert.Equal(t, "Population is...", page)
+}
+
+func TestCutRest(t *testing.T) {
+	text := "First sentence here. " + strings.Repeat("word ", 40)
+	page, n := Cut(text, 1, "")
+	assert.Equal(t, "First sentence here. word word", page[:30])
+	rest := []rune(text)[n:]
+	assert.Equal(t, "word", string(rest[:4]))
+
+	text = "Short one. " + strings.Repeat("long ", 40)
+	page, _ = Cut(text, 1, "")
+	assert.True(t, strings.HasSuffix(page, "long..."), page)
+}
+
+func TestCutEllipsis(t *testing.T) {
+	page, _ := Cut(strings.Repeat("слово ", 30), 1, "")
+	assert.True(t, strings.HasSuffix(page, "слово"+Ellipsis), page)
+	assert.Equal(t, smsenc.UCS2, smsenc.Detect(page))
+	page, _ = Cut(strings.Repeat("word ", 50), 1, "")
+	assert.True(t, strings.HasSuffix(page, "word"+EllipsisGSM7), page)
+	assert.Equal(t, smsenc.GSM7, smsenc.Detect(page))
+}
+
+func TestCutConverted(t *testing.T) {
+	text := strings.Repeat("щи ", 60)
+	wide := func(s string) string {
+		return strings.NewReplacer("щ", "shch", "и", "i", Ellipsis, EllipsisGSM7).Replace(s)
+	}
+	page, n := CutConverted(text, 1, "", wide)
+	assert.True(t, strings.HasPrefix(text, strings.TrimSuffix(page, Ellipsis)), "not converted")
+	assert.Equal(t, 1, smsenc.Segments(wide(page)))
+	assert.True(t, utf8.RuneCountInString(page) > 70, "fits GSM-7 once converted")
+	assert.Equal(t, "щи", string([]rune(text)[n:n+2]))
+}
@@ -0,0 +1,110 @@
+// Package translit transliterates Cyrillic text to Latin, so it can be sent
+// in the GSM 7-bit alphabet that holds more than twice as much per SMS.
+package translit
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Table is a transliteration system for Russian Cyrillic.
+type Table struct {
+	Name    string
+	letters map[rune]string
+	// soft is used instead of letters before е, и, й and ы.
+	soft map[rune]string
+}
+
+// GOST is GOST 7.79-2000 system B, backticks are replaced with apostrophes
+// as they don't belong to GSM-7.
+var GOST = &Table{
+	Name: "gost",
+	letters: map[rune]string{
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+		'ж': "zh", 'з': "z", 'и': "i", 'й': "j", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+		'ф': "f", 'х': "x", 'ц': "cz", 'ч': "ch", 'ш': "sh", 'щ': "shh", 'ъ': "''",
+		'ы': "y'", 'ь': "'", 'э': "e'", 'ю': "yu", 'я': "ya",
+	},
+	soft: map[rune]string{'ц': "c"},
+}
+
+// ICAO is the ICAO Doc 9303 system used in Russian passports.
+var ICAO = &Table{
+	Name: "icao",
+	letters: map[rune]string{
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+		'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+		'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "ie",
+		'ы': "y", 'ь': "", 'э': "e", 'ю': "iu", 'я': "ia",
+	},
+}
+
+var tables = map[string]*Table{
+	GOST.Name: GOST,
+	ICAO.Name: ICAO,
+}
+
+// Lookup returns the table by name or nil if there's no such table.
+func Lookup(name string) *Table {
+	return tables[strings.ToLower(name)]
+}
+
+// punct replaces typographic characters that are common in Russian texts
+// but don't belong to GSM-7.
+var punct = map[rune]string{
+	'—': "-", '–': "-", '«': "\"", '»': "\"", '„': "\"", '“': "\"", '”': "\"",
+	'’': "'", '…': "...", '№': "No", ' ': " ",
+}
+
+func softNext(r rune) bool {
+	return strings.ContainsRune("еийы", unicode.ToLower(r))
+}
+
+// Convert transliterates the text with the table, letters it doesn't
+// know are kept as is and combining marks like stress accents are dropped.
+func Convert(text string, t *Table) string {
+	runes := []rune(text)
+	out := make([]string, 0, len(runes))
+	for i, r := range runes {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if s, ok := punct[r]; ok {
+			out = append(out, s)
+			continue
+		}
+		lower := unicode.ToLower(r)
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		s, ok := t.soft[lower]
+		if !ok || !softNext(next) {
+			if s, ok = t.letters[lower]; !ok {
+				out = append(out, string(r))
+				continue
+			}
+		}
+		if r != lower {
+			s = upper(s, runes, i)
+		}
+		out = append(out, s)
+	}
+	return strings.Join(out, "")
+}
+
+// upper capitalizes the transliterated capital letter, a whole word
+// in capitals stays in capitals.
+func upper(s string, runes []rune, i int) string {
+	if len(s) < 1 {
+		return s
+	}
+	caps := i+1 < len(runes) && unicode.IsUpper(runes[i+1]) ||
+		i > 0 && unicode.IsUpper(runes[i-1]) && (i+1 == len(runes) || !unicode.IsLetter(runes[i+1]))
+	if caps {
+		return strings.ToUpper(s)
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,37 @@
+package translit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/smsenc"
+)
+
+func TestConvertICAO(t *testing.T) {
+	assert.Equal(t, "Shchukin", Convert("Щукин", ICAO))
+	assert.Equal(t, "Lopata - ruchnoi instrument", Convert("Лопа́та — ручной инструмент", ICAO))
+	assert.Equal(t, "Moskva, 13 274 285 chel.", Convert("Москва, 13 274 285 чел.", ICAO))
+	assert.Equal(t, "SHCHI i borshch", Convert("ЩИ и борщ", ICAO))
+	assert.Equal(t, "Ob'ekt", Convert("Ob'ekt", ICAO))
+	assert.Equal(t, "obieekt", Convert("объект", ICAO))
+}
+
+func TestConvertGOST(t *testing.T) {
+	assert.Equal(t, "cirk i czaplya", Convert("цирк и цапля", GOST))
+	assert.Equal(t, "Yozh s''el e'to", Convert("Ёж съел это", GOST))
+	assert.Equal(t, "TSAR", Convert("ЦАРЬ", ICAO))
+	assert.Equal(t, "CZAR'", Convert("ЦАРЬ", GOST))
+}
+
+func TestConvertGSM7(t *testing.T) {
+	text := "Москва́ — столица России «Москва» № 1… Население — 13 млн чел."
+	for _, table := range []*Table{GOST, ICAO} {
+		assert.Equal(t, smsenc.GSM7, smsenc.Detect(Convert(text, table)), table.Name)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert.Equal(t, GOST, Lookup("gost"))
+	assert.Equal(t, ICAO, Lookup("ICAO"))
+	assert.Nil(t, Lookup("iso9"))
+}
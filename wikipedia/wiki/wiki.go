@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -26,7 +27,7 @@ func main() {
 		var res *wikipedia.SearchSuggestion
 
 		search := func(lang wikipedia.Language, search string) (desc string, err error) {
-			res, err = api.Query(lang, search)
+			res, err = api.Query(context.Background(), lang, search)
 			if err != nil {
 				return
 			}
@@ -1,28 +1,43 @@
 package wikipedia
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/xlab/api"
+	"github.com/xlab/smscloud/misc"
 )
 
-const baseRU = "http://ru.wikipedia.org/w/api.php"
-const baseEN = "http://en.wikipedia.org/w/api.php"
+const baseURI = "http://%s.wikipedia.org/w/api.php"
 
-type Language int
+// Language is a wiki language code, e.g. en, ru or zh-yue.
+type Language string
 
 const (
-	RU Language = iota
-	EN
+	RU Language = "ru"
+	EN Language = "en"
 )
 
+var langCode = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]+)*$`)
+
+// Valid checks if the code looks like a wiki language code.
+func (l Language) Valid() bool {
+	return langCode.MatchString(string(l))
+}
+
 var (
-	ErrUnknown = errors.New("wikipedia: unknown error")
-	ErrEmpty   = errors.New("wikipedia: empty input")
+	ErrUnknown  = errors.New("wikipedia: unknown error")
+	ErrEmpty    = errors.New("wikipedia: empty input")
+	ErrLanguage = errors.New("wikipedia: invalid language code")
 )
 
 type Item struct {
@@ -42,6 +57,72 @@ type SearchSuggestion struct {
 	Items []*Item `xml:"Section>Item"`
 }
 
+type Redirect struct {
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+}
+
+type Page struct {
+	PageID  int        `xml:"pageid,attr"`
+	Title   string     `xml:"title,attr"`
+	Extract string     `xml:"extract"`
+	Props   *PageProps `xml:"pageprops"`
+}
+
+type PageProps struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// Disambiguation reports whether the page is a disambiguation page.
+func (p *Page) Disambiguation() bool {
+	if p.Props == nil {
+		return false
+	}
+	for _, attr := range p.Props.Attrs {
+		if attr.Name.Local == "disambiguation" {
+			return true
+		}
+	}
+	return false
+}
+
+type ExtractResult struct {
+	Err        *Err        `xml:"error"`
+	Normalized []*Redirect `xml:"query>normalized>n"`
+	Redirects  []*Redirect `xml:"query>redirects>r"`
+	Pages      []*Page     `xml:"query>pages>page"`
+}
+
+// Page returns the first existing page of the result or nil.
+func (r *ExtractResult) Page() *Page {
+	for _, p := range r.Pages {
+		if p.PageID > 0 {
+			return p
+		}
+	}
+	return nil
+}
+
+type FeedItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+}
+
+type FeaturedFeed struct {
+	Title string      `xml:"channel>title"`
+	Items []*FeedItem `xml:"channel>item"`
+}
+
+// Latest returns the most recent feed item or nil.
+func (f *FeaturedFeed) Latest() *FeedItem {
+	if len(f.Items) < 1 {
+		return nil
+	}
+	return f.Items[len(f.Items)-1]
+}
+
 type Err struct {
 	Code string `xml:"code,attr"`
 	Info string `xml:"info,attr"`
@@ -52,41 +133,101 @@ func (e *Err) Error() error {
 }
 
 type Api struct {
-	RU *api.Api
-	EN *api.Api
+	// Client is used to make requests, misc.DefaultHTTPClient if nil.
+	Client *http.Client
+
+	mux  sync.Mutex
+	base string
+	apis map[Language]*api.Api
 }
 
 func NewApi() *Api {
 	return &Api{
-		RU: api.MustNew(baseRU),
-		EN: api.MustNew(baseEN),
+		base: baseURI,
+		apis: make(map[Language]*api.Api),
+	}
+}
+
+// SetBaseURL points the Api to another endpoint, e.g. a test server.
+// The base may contain %s to be replaced with the language code.
+func (a *Api) SetBaseURL(base string) {
+	a.mux.Lock()
+	a.base = base
+	a.apis = make(map[Language]*api.Api)
+	a.mux.Unlock()
+}
+
+// wiki returns the API of the language wiki, it's created on first use.
+func (a *Api) wiki(lang Language) (*api.Api, error) {
+	if !lang.Valid() {
+		return nil, ErrLanguage
 	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if w, ok := a.apis[lang]; ok {
+		return w, nil
+	}
+	base := a.base
+	if strings.Contains(base, "%s") {
+		base = fmt.Sprintf(base, lang)
+	}
+	w, err := api.New(base)
+	if err != nil {
+		return nil, err
+	}
+	a.apis[lang] = w
+	return w, nil
+}
+
+func (a *Api) Query(ctx context.Context, lang Language, input string) (res *SearchSuggestion, err error) {
+	return a.Search(ctx, lang, input, 1)
 }
 
-func (a *Api) Query(lang Language, input string) (res *SearchSuggestion, err error) {
+// Search is like Query but returns up to limit suggestions.
+func (a *Api) Search(ctx context.Context, lang Language, input string, limit int) (res *SearchSuggestion, err error) {
 	if len(input) < 1 {
 		err = ErrEmpty
 		return
 	}
-	var cli http.Client
-	var req *http.Request
-	var resp *http.Response
 	args := url.Values{}
 	args.Set("search", input)
-	if req, err = a.request(lang, args); err != nil {
+	args.Set("limit", strconv.Itoa(limit))
+	var data []byte
+	if data, err = a.fetch(ctx, lang, args); err != nil {
 		return
 	}
-	// do a request
-	if resp, err = cli.Do(req); err != nil {
+	// parse result
+	res = &SearchSuggestion{}
+	if err = xml.Unmarshal(data, res); err != nil {
 		return
 	}
-	defer resp.Body.Close()
+	if res.Err != nil {
+		return nil, res.Err.Error()
+	}
+	return
+}
+
+// Extract fetches the plain text intro of an article along with its
+// disambiguation flag, redirects are followed.
+func (a *Api) Extract(ctx context.Context, lang Language, title string) (res *ExtractResult, err error) {
+	if len(title) < 1 {
+		err = ErrEmpty
+		return
+	}
+	args := url.Values{}
+	args.Set("action", "query")
+	args.Set("prop", "extracts|pageprops")
+	args.Set("ppprop", "disambiguation")
+	args.Set("exintro", "1")
+	args.Set("explaintext", "1")
+	args.Set("redirects", "1")
+	args.Set("titles", title)
 	var data []byte
-	if data, err = ioutil.ReadAll(resp.Body); err != nil {
+	if data, err = a.fetch(ctx, lang, args); err != nil {
 		return
 	}
 	// parse result
-	res = &SearchSuggestion{}
+	res = &ExtractResult{}
 	if err = xml.Unmarshal(data, res); err != nil {
 		return
 	}
@@ -96,15 +237,58 @@ func (a *Api) Query(lang Language, input string) (res *SearchSuggestion, err err
 	return
 }
 
+// Featured fetches the featured articles feed, it's available on EN only.
+func (a *Api) Featured(ctx context.Context, lang Language) (res *FeaturedFeed, err error) {
+	args := url.Values{}
+	args.Set("action", "featuredfeed")
+	args.Set("feed", "featured")
+	args.Set("feedformat", "rss")
+	var data []byte
+	if data, err = a.fetch(ctx, lang, args); err != nil {
+		return
+	}
+	// parse result
+	res = &FeaturedFeed{}
+	if err = xml.Unmarshal(data, res); err != nil {
+		return
+	}
+	if len(res.Items) < 1 {
+		return nil, ErrUnknown
+	}
+	return
+}
+
+func (a *Api) fetch(ctx context.Context, lang Language, args url.Values) (data []byte, err error) {
+	var req *http.Request
+	var resp *http.Response
+	if req, err = a.request(lang, args); err != nil {
+		return
+	}
+	// do a request
+	if resp, err = a.client().Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (a *Api) client() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return misc.DefaultHTTPClient
+}
+
 func (a *Api) request(lang Language, args url.Values) (*http.Request, error) {
 	base := a.values()
 	for k := range args {
-		base.Add(k, args.Get(k))
+		base.Set(k, args.Get(k))
 	}
-	if lang == RU {
-		return a.RU.Request(api.GET, "", base)
+	w, err := a.wiki(lang)
+	if err != nil {
+		return nil, err
 	}
-	return a.EN.Request(api.GET, "", base)
+	return w.Request(api.GET, "", base)
 }
 
 func (a *Api) values() url.Values {
@@ -1,16 +1,36 @@
 package wikipedia
 
 import (
+	"context"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/replay"
 )
 
-func TestQueryRU(t *testing.T) {
+// newReplayApi returns an Api that replays the named fixture from testdata.
+func newReplayApi(t *testing.T, fixture string) (*Api, *replay.Transport) {
+	tr, err := replay.Open(filepath.Join("testdata", fixture+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
 	api := NewApi()
+	api.Client = tr.Client()
+	return api, tr
+}
+
+func TestQueryRU(t *testing.T) {
+	api, tr := newReplayApi(t, "query_ru")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
 	query := "лопата"
-	res, err := api.Query(RU, query)
+	res, err := api.Query(context.Background(), RU, query)
 	if !assert.NoError(t, err) {
 		return
 	}
@@ -22,9 +42,12 @@ func TestQueryRU(t *testing.T) {
 }
 
 func TestQueryEN(t *testing.T) {
-	api := NewApi()
+	api, tr := newReplayApi(t, "query_en")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
 	query := "shovel"
-	res, err := api.Query(EN, query)
+	res, err := api.Query(context.Background(), EN, query)
 	if !assert.NoError(t, err) {
 		return
 	}
@@ -34,3 +57,97 @@ func TestQueryEN(t *testing.T) {
 		log.Printf("Item %s:\n%s (%s)\n\n", it.Text, it.Description, it.URL)
 	}
 }
+
+func TestFeaturedEN(t *testing.T) {
+	api, tr := newReplayApi(t, "featured_en")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
+	res, err := api.Featured(context.Background(), EN)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEmpty(t, res.Items)
+	if it := res.Latest(); assert.NotNil(t, it) {
+		log.Printf("Featured %s (%s)\n\n", it.Title, it.Link)
+	}
+}
+
+func TestExtractEN(t *testing.T) {
+	api, tr := newReplayApi(t, "extract_en")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
+	res, err := api.Extract(context.Background(), EN, "shovels")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEmpty(t, res.Redirects)
+	if page := res.Page(); assert.NotNil(t, page) {
+		assert.Equal(t, "Shovel", page.Title)
+		assert.NotEmpty(t, page.Extract)
+		assert.False(t, page.Disambiguation())
+		log.Printf("Page %s:\n%s\n\n", page.Title, page.Extract)
+	}
+}
+
+func TestQueryDE(t *testing.T) {
+	api, tr := newReplayApi(t, "query_de")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
+	query := "Schaufel"
+	res, err := api.Query(context.Background(), Language("de"), query)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, query, res.Query)
+	assert.NotEmpty(t, res.Items)
+}
+
+func TestLanguageValid(t *testing.T) {
+	assert.True(t, RU.Valid())
+	assert.True(t, Language("zh-yue").Valid())
+	assert.False(t, Language("").Valid())
+	assert.False(t, Language("evil.com/").Valid())
+	_, err := NewApi().Query(context.Background(), Language("EN"), "shovel")
+	assert.Equal(t, ErrLanguage, err)
+}
+
+func TestDisambiguationEN(t *testing.T) {
+	api, tr := newReplayApi(t, "disambiguation_en")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
+	res, err := api.Search(context.Background(), EN, "mercury", 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, len(res.Items) > 1)
+	ext, err := api.Extract(context.Background(), EN, "Mercury")
+	if !assert.NoError(t, err) {
+		return
+	}
+	if page := ext.Page(); assert.NotNil(t, page) {
+		assert.True(t, page.Disambiguation())
+	}
+}
+
+func TestQueryBaseURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/de/w/api.php", r.URL.Path)
+		io.WriteString(w, `<SearchSuggestion><Query>Schaufel</Query><Section>`+
+			`<Item><Text>Schaufel</Text><Description>Eine Schaufel ist ein Werkzeug.</Description>`+
+			`<Url>http://de.wikipedia.org/wiki/Schaufel</Url></Item></Section></SearchSuggestion>`)
+	}))
+	defer srv.Close()
+	api := NewApi()
+	api.SetBaseURL(srv.URL + "/%s/w/api.php")
+	res, err := api.Query(context.Background(), Language("de"), "Schaufel")
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, res.Items, 1) {
+		assert.Equal(t, "Eine Schaufel ist ein Werkzeug.", res.Items[0].Description)
+	}
+}
@@ -1,17 +1,23 @@
 package wolfram
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/xlab/api"
+	"github.com/xlab/smscloud/misc"
 )
 
 const baseURI = "http://api.wolframalpha.com/v2"
 
+// inputPodID is the ID of the input interpretation pod.
+const inputPodID = "Input"
+
 var (
 	ErrUnknown = errors.New("wolfram: unknown error")
 	ErrEmpty   = errors.New("wolfram: empty input")
@@ -29,12 +35,80 @@ type Pod struct {
 	SubPods []*SubPod `xml:"subpod"`
 }
 
+// Text joins non-empty plaintext of the subpods.
+func (p *Pod) Text() string {
+	list := make([]string, 0, len(p.SubPods))
+	for _, sub := range p.SubPods {
+		if text := strings.TrimSpace(sub.Plaintext); len(text) > 0 {
+			list = append(list, text)
+		}
+	}
+	return strings.Join(list, "; ")
+}
+
+type AssumptionValue struct {
+	Name  string `xml:"name,attr"`
+	Desc  string `xml:"desc,attr"`
+	Input string `xml:"input,attr"`
+}
+
+type Assumption struct {
+	Type     string             `xml:"type,attr"`
+	Word     string             `xml:"word,attr"`
+	Template string             `xml:"template,attr"`
+	Values   []*AssumptionValue `xml:"value"`
+}
+
+type DidYouMean struct {
+	Score float32 `xml:"score,attr"`
+	Level string  `xml:"level,attr"`
+	Text  string  `xml:",chardata"`
+}
+
+type Tip struct {
+	Text string `xml:"text,attr"`
+}
+
 type QueryResult struct {
-	IsSuccess bool    `xml:"success,attr"`
-	IsError   bool    `xml:"error,attr"`
-	Timing    float32 `xml:"timing,attr"`
-	Err       *Err    `xml:"error"`
-	Pods      []*Pod  `xml:"pod"`
+	IsSuccess   bool          `xml:"success,attr"`
+	IsError     bool          `xml:"error,attr"`
+	Timing      float32       `xml:"timing,attr"`
+	Err         *Err          `xml:"error"`
+	Pods        []*Pod        `xml:"pod"`
+	Assumptions []*Assumption `xml:"assumptions>assumption"`
+	DidYouMeans []*DidYouMean `xml:"didyoumeans>didyoumean"`
+	Tips        []*Tip        `xml:"tips>tip"`
+}
+
+// Result picks the pod most likely holding the answer: the primary one,
+// otherwise the first non-input pod with some text. It returns nil if
+// there is no such pod.
+func (r *QueryResult) Result() *Pod {
+	for _, pod := range r.Pods {
+		if pod.Primary && len(pod.Text()) > 0 {
+			return pod
+		}
+	}
+	for _, pod := range r.Pods {
+		if pod.ID != inputPodID && len(pod.Text()) > 0 {
+			return pod
+		}
+	}
+	return nil
+}
+
+// Suggestion returns the best scored did you mean text or an empty string.
+func (r *QueryResult) Suggestion() string {
+	var best *DidYouMean
+	for _, d := range r.DidYouMeans {
+		if best == nil || d.Score > best.Score {
+			best = d
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return strings.TrimSpace(best.Text)
 }
 
 type Err struct {
@@ -49,6 +123,8 @@ func (e *Err) Error() error {
 type Api struct {
 	Key      string
 	Location string
+	// Client is used to make requests, misc.DefaultHTTPClient if nil.
+	Client *http.Client
 	*api.Api
 }
 
@@ -60,12 +136,23 @@ func NewApi(key string, loc string) *Api {
 	}
 }
 
-func (a *Api) Query(input string) (res *QueryResult, err error) {
+// SetBaseURL points the Api to another endpoint, e.g. a test server.
+func (a *Api) SetBaseURL(base string) (err error) {
+	var b *api.Api
+	if b, err = api.New(base); err != nil {
+		return
+	}
+	a.Api = b
+	return
+}
+
+// Query computes the input. If nothing could be computed it returns
+// ErrUnknown along with the result, that may carry suggestions and tips.
+func (a *Api) Query(ctx context.Context, input string) (res *QueryResult, err error) {
 	if len(input) < 1 {
 		err = ErrEmpty
 		return
 	}
-	var cli http.Client
 	var req *http.Request
 	var resp *http.Response
 	args := url.Values{}
@@ -74,7 +161,7 @@ func (a *Api) Query(input string) (res *QueryResult, err error) {
 		return
 	}
 	// do a request
-	if resp, err = cli.Do(req); err != nil {
+	if resp, err = a.client().Do(req.WithContext(ctx)); err != nil {
 		return
 	}
 	defer resp.Body.Close()
@@ -91,11 +178,18 @@ func (a *Api) Query(input string) (res *QueryResult, err error) {
 		return nil, res.Err.Error()
 	}
 	if !res.IsSuccess {
-		return nil, ErrUnknown
+		return res, ErrUnknown
 	}
 	return
 }
 
+func (a *Api) client() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return misc.DefaultHTTPClient
+}
+
 func (a *Api) request(m api.Method, res string, args url.Values) (*http.Request, error) {
 	base := a.values()
 	for k := range args {
@@ -1,17 +1,39 @@
 package wolfram
 
 import (
+	"context"
+	"encoding/xml"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xlab/smscloud/replay"
 )
 
 const apiKey = "A6393P-2Y4J5AA4UW"
 
-func TestQuery(t *testing.T) {
+// newReplayApi returns an Api that replays the named fixture from testdata.
+func newReplayApi(t *testing.T, fixture string) (*Api, *replay.Transport) {
+	tr, err := replay.Open(filepath.Join("testdata", fixture+".json"), "appid")
+	if err != nil {
+		t.Fatal(err)
+	}
 	api := NewApi(apiKey, "Russia")
-	res, err := api.Query("rouble")
+	api.Client = tr.Client()
+	return api, tr
+}
+
+func TestQuery(t *testing.T) {
+	api, tr := newReplayApi(t, "query_rouble")
+	defer func() {
+		assert.NoError(t, tr.Close())
+	}()
+	res, err := api.Query(context.Background(), "rouble")
 	if !assert.NoError(t, err) {
 		return
 	}
@@ -22,3 +44,97 @@ func TestQuery(t *testing.T) {
 		log.Printf("Pod %s:\n%s\n\n", pod.Title, pod.SubPods[0].Plaintext)
 	}
 }
+
+const sampleResult = `<queryresult success='true' error='false' numpods='3'>
+ <pod title='Input interpretation' id='Input'>
+  <subpod title=''><plaintext>pi</plaintext></subpod>
+ </pod>
+ <pod title='Decimal approximation' id='DecimalApproximation'>
+  <subpod title=''><plaintext>3.1415926535897932384626433832795028841971693993751058209749445923...</plaintext></subpod>
+ </pod>
+ <pod title='Property' id='Property' primary='true'>
+  <subpod title=''><plaintext>pi is a transcendental number</plaintext></subpod>
+ </pod>
+ <assumptions count='1'>
+  <assumption type='Clash' word='pi' template='Assuming "${word}" is ${desc1}. Use as ${desc2} instead' count='2'>
+   <value name='NamedConstant' desc='a mathematical constant' input='*C.pi-_*NamedConstant-' />
+   <value name='Character' desc='a character' input='*C.pi-_*Character-' />
+  </assumption>
+ </assumptions>
+</queryresult>`
+
+const sampleFailure = `<queryresult success='false' error='false' numpods='0'>
+ <didyoumeans count='2'>
+  <didyoumean score='0.415939' level='medium'>weather</didyoumean>
+  <didyoumean score='0.2' level='low'>wealth</didyoumean>
+ </didyoumeans>
+ <tips count='1'>
+  <tip text='Check your spelling, and use English' />
+ </tips>
+</queryresult>`
+
+func TestResult(t *testing.T) {
+	res := &QueryResult{}
+	if !assert.NoError(t, xml.Unmarshal([]byte(sampleResult), res)) {
+		return
+	}
+	pod := res.Result()
+	if assert.NotNil(t, pod) {
+		assert.Equal(t, "Property", pod.ID)
+		assert.Equal(t, "pi is a transcendental number", pod.Text())
+	}
+	if assert.Len(t, res.Assumptions, 1) {
+		assert.Equal(t, "pi", res.Assumptions[0].Word)
+		assert.Len(t, res.Assumptions[0].Values, 2)
+	}
+	res.Pods[2].Primary = false
+	if pod = res.Result(); assert.NotNil(t, pod) {
+		assert.Equal(t, "DecimalApproximation", pod.ID)
+	}
+	assert.Empty(t, res.Suggestion())
+}
+
+func TestSuggestion(t *testing.T) {
+	res := &QueryResult{}
+	if !assert.NoError(t, xml.Unmarshal([]byte(sampleFailure), res)) {
+		return
+	}
+	assert.False(t, res.IsSuccess)
+	assert.Nil(t, res.Result())
+	assert.Equal(t, "weather", res.Suggestion())
+	if assert.Len(t, res.Tips, 1) {
+		assert.Equal(t, "Check your spelling, and use English", res.Tips[0].Text)
+	}
+}
+
+func TestQueryBaseURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/query", r.URL.Path)
+		assert.Equal(t, "pi", r.URL.Query().Get("input"))
+		io.WriteString(w, sampleResult)
+	}))
+	defer srv.Close()
+	api := NewApi(apiKey, "Russia")
+	if !assert.NoError(t, api.SetBaseURL(srv.URL)) {
+		return
+	}
+	res, err := api.Query(context.Background(), "pi")
+	if assert.NoError(t, err) {
+		assert.Len(t, res.Pods, 3)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+	api := NewApi(apiKey, "Russia")
+	api.SetBaseURL(srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := api.Query(ctx, "pi")
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -29,14 +30,20 @@ func init() {
 func main() {
 	app.Action = func(c *cli.Context) {
 		api := wolfram.NewApi(c.String("key"), "Russia")
-		res, err := api.Query(c.Args().First())
+		res, err := api.Query(context.Background(), c.Args().First())
+		if err == wolfram.ErrUnknown {
+			if s := res.Suggestion(); len(s) > 0 {
+				log.Fatalln("wolfram: did you mean", s)
+			}
+		}
 		if err != nil {
 			log.Fatalln(err)
 		}
-		if len(res.Pods) < 2 || len(res.Pods[1].SubPods) < 1 {
+		pod := res.Result()
+		if pod == nil {
 			log.Fatalln("wolfram: unable to compute any result")
 		}
-		fmt.Println(res.Pods[1].SubPods[0].Plaintext)
+		fmt.Println(pod.Text())
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatalln(err)